@@ -0,0 +1,186 @@
+package anydb
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	aerospike "github.com/aerospike/aerospike-client-go"
+)
+
+func init() {
+	Register("aerospike", openAerospike, nil)
+}
+
+// aerospikeValueBin is the bin used by Put for raw values
+const aerospikeValueBin = "value"
+
+// aerospikeDB is a connection to an aerospike server
+// Records are addressed with namespace and set, see SetContext
+type aerospikeDB struct {
+	client    *aerospike.Client
+	namespace string
+	set       string
+}
+
+func openAerospike(path string, o Options) (Backend, error) {
+	policy := aerospike.NewClientPolicy()
+	policy.Timeout = 5000 * time.Millisecond
+	client, err := aerospike.NewClientWithPolicy(policy, path, 3000)
+	if err != nil {
+		return nil, err
+	}
+	return &aerospikeDB{client: client}, nil
+}
+
+func (db *aerospikeDB) Scan() error {
+	return nil
+}
+
+func (db *aerospikeDB) Reset() error {
+	return notSupported("aerospike", "Reset")
+}
+
+func (db *aerospikeDB) Next() bool {
+	return false
+}
+
+func (db *aerospikeDB) Key() []byte {
+	return nil
+}
+
+func (db *aerospikeDB) Value() []byte {
+	return nil
+}
+
+func (db *aerospikeDB) Entries() uint64 {
+	return 0
+}
+
+// EstimateEntries sums the objects of the selected namespace and set over all nodes
+// and divides them by the replication factor. Counts are updated by the server periodically
+func (db *aerospikeDB) EstimateEntries() (uint64, error) {
+	if db.namespace == "" {
+		return 0, errors.New("No namespace selected")
+	}
+	ns := "namespace/" + db.namespace
+	set := "sets/" + db.namespace + "/" + db.set
+	var objects, replication uint64
+	for _, node := range db.client.GetNodes() {
+		info, err := aerospike.RequestNodeInfo(node, ns, set)
+		if err != nil {
+			return 0, err
+		}
+		if db.set != "" {
+			objects += aerospikeInfoValue(info[set], "objects")
+		} else {
+			objects += aerospikeInfoValue(info[ns], "objects")
+		}
+		if r := aerospikeInfoValue(info[ns], "replication-factor"); r > replication {
+			replication = r
+		}
+	}
+	if replication == 0 {
+		replication = 1
+	}
+	return objects / replication, nil
+}
+
+// Stat returns the memory and disk usage of the selected namespace on every node
+func (db *aerospikeDB) Stat() ([]Property, error) {
+	var p []Property
+	for _, node := range db.client.GetNodes() {
+		p = append(p, Property{"node", node.GetName()})
+		if db.namespace == "" {
+			continue
+		}
+		ns := "namespace/" + db.namespace
+		info, err := aerospike.RequestNodeInfo(node, ns)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range []string{"objects", "used-bytes-memory", "used-bytes-disk"} {
+			p = append(p, Property{name, strconv.FormatUint(aerospikeInfoValue(info[ns], name), 10)})
+		}
+	}
+	return p, nil
+}
+
+// aerospikeInfoValue returns a numeric field of an info response like "objects=10;tombstones=0"
+func aerospikeInfoValue(info string, name string) uint64 {
+	fields := strings.FieldsFunc(info, func(r rune) bool { return r == ';' || r == ':' })
+	for _, f := range fields {
+		if strings.HasPrefix(f, name+"=") {
+			v, _ := strconv.ParseUint(f[len(name)+1:], 10, 64)
+			return v
+		}
+	}
+	return 0
+}
+
+// key returns an aerospike key in the namespace and set given by SetContext
+func (db *aerospikeDB) key(key []byte) (*aerospike.Key, error) {
+	if db.namespace == "" {
+		return nil, errors.New("No namespace selected")
+	}
+	return aerospike.NewKey(db.namespace, db.set, key)
+}
+
+// Put stores value as raw bytes in the value bin
+func (db *aerospikeDB) Put(key []byte, value []byte) error {
+	k, err := db.key(key)
+	if err != nil {
+		return err
+	}
+	return db.client.PutBins(nil, k, aerospike.NewBin(aerospikeValueBin, value))
+}
+
+func (db *aerospikeDB) Delete(key []byte) error {
+	k, err := db.key(key)
+	if err != nil {
+		return err
+	}
+	existed, err := db.client.Delete(nil, k)
+	if err == nil && !existed {
+		err = errors.New("Not found")
+	}
+	return err
+}
+
+func (db *aerospikeDB) getRecord(key []byte) (record *aerospike.Record, err error) {
+	k, err := db.key(key)
+	if err != nil {
+		return
+	}
+	return db.client.Get(nil, k)
+}
+
+func (db *aerospikeDB) radiusSearch(namespace string, set string, bin string, lat, lng float64, radius float64) (*aerospike.Recordset, error) {
+	stm := aerospike.NewStatement(namespace, set)
+	stm.Addfilter(aerospike.NewGeoWithinRadiusFilter(bin, lng, lat, radius))
+	return db.client.Query(nil, stm)
+}
+
+func (db *aerospikeDB) putGeoJSON(namespace string, set string, bin string, key []byte, json string) error {
+	asKey, err := aerospike.NewKey(namespace, set, key)
+	if err != nil {
+		return err
+	}
+	bin1 := aerospike.NewBin("key", string(key))
+	bin2 := aerospike.NewBin(bin, aerospike.NewGeoJSONValue(json))
+	return db.client.PutBins(nil, asKey, bin1, bin2)
+}
+
+func (db *aerospikeDB) show(info string) (map[string]string, error) {
+	nodes := db.client.GetNodes()
+	return aerospike.RequestNodeInfo(nodes[0], info)
+}
+
+func (db *aerospikeDB) Release() {
+}
+
+func (db *aerospikeDB) Close() error {
+	db.client.Close()
+	return nil
+}
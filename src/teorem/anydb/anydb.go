@@ -1,7 +1,11 @@
 /*
 Package anydb provides a common lib agains different key-value storage
-Currently supported: lmdb, leveldb
-Might be supported in the future: bolt, aerospike
+Currently supported: lmdb, leveldb, bolt, aerospike, folders, image folders with
+one subfolder per class, CSV/TSV files and text files, plain or compressed with gzip,
+bzip2 or zstd
+
+Every kind of storage is a Backend that registers itself with Register,
+which makes it available to Open and Create under its identity.
 */
 package anydb
 
@@ -11,136 +15,292 @@ import (
 	"errors"
 	"fmt"
 	"image"
-	"io/ioutil"
-	"math/rand"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
-
-	"github.com/bmatsuo/lmdb-go/lmdb"
-	"github.com/disintegration/imaging"
-	"github.com/syndtr/goleveldb/leveldb"
-	"github.com/syndtr/goleveldb/leveldb/iterator"
-	"github.com/syndtr/goleveldb/leveldb/opt"
-	"github.com/syndtr/goleveldb/leveldb/util"
+	"unicode"
 
 	aerospike "github.com/aerospike/aerospike-client-go"
 )
 
+// Backend is implemented by every kind of storage anydb can open
+// Optional features are implemented through the Getter, Putter, Deleter, Batcher,
+// Seeker, Reverser, RandomGetter, Estimator, Sizer, Stater, Imager, Labeler, BucketSetter,
+// Cursorer and Follower interfaces
+type Backend interface {
+	// Scan setups iterator/cursor if there is none
+	Scan() error
+	// Reset moves the iterator to the first record
+	Reset() error
+	// Next moves the iterator to the next record, returns false at the end
+	Next() bool
+	// Key returns key of current iterator
+	Key() []byte
+	// Value returns value of current iterator
+	Value() []byte
+	// Entries returns number of records, 0 if unknown
+	Entries() uint64
+	// Release frees the iterator if there is one
+	Release()
+	// Close closes the database
+	Close() error
+}
+
+// Getter is implemented by backends with random access by key
+type Getter interface {
+	Get(key []byte) ([]byte, error)
+}
+
+// Putter is implemented by writable backends
+type Putter interface {
+	Put(key []byte, value []byte) error
+}
+
+// Deleter is implemented by backends where records can be removed
+type Deleter interface {
+	Delete(key []byte) error
+}
+
+// Seeker is implemented by backends that can move the iterator to the first key >= key
+// Seeking past the last key leaves the iterator with nil Key and Value
+type Seeker interface {
+	Seek(key []byte) error
+}
+
+// Reverser is implemented by backends that can iterate backwards
+// Last moves to the last record, Prev to the previous one and returns false at the top
+type Reverser interface {
+	Last() error
+	Prev() bool
+}
+
+// RandomGetter is implemented by backends that can return a random record
+type RandomGetter interface {
+	GetRandom() (key []byte, value []byte, err error)
+}
+
+// Sizer is implemented by backends that can estimate the size of a key range
+type Sizer interface {
+	SizeOf(start []byte, stop []byte) (int64, error)
+}
+
+// Stater is implemented by backends with internal statistics
+type Stater interface {
+	Stat() ([]Property, error)
+}
+
+// Imager is implemented by backends that can decode the current value as an image
+type Imager interface {
+	Image() (image.Image, error)
+}
+
+// Labeler is implemented by backends that know the class label of their records
+type Labeler interface {
+	Classes() []string
+	LabelOf(key []byte) (int32, error)
+}
+
+// BucketSetter is implemented by backends holding several named buckets (or sub-databases)
+type BucketSetter interface {
+	Buckets() ([]string, error)
+	SetBucket(name string) error
+	Bucket() string
+}
+
+// Follower is implemented by backends that can pick up records appended while iterating
+// Follow makes the records written since the iterator was set up visible and moves to the
+// first record after the key last, or to the first record if last is empty. It returns
+// false if there is none yet. Files continue after the last line read instead
+type Follower interface {
+	Follow(last []byte) bool
+}
+
+// OpenFunc opens an existing database located at path, backends ignore options they don't know
+type OpenFunc func(path string, o Options) (Backend, error)
+
+// CreateFunc sets up a new database at path, or opens the one already there
+type CreateFunc func(path string, o Options) (Backend, error)
+
+type driver struct {
+	open   OpenFunc
+	create CreateFunc
+}
+
+var drivers = make(map[string]driver)
+
+// Register makes a backend available to Open and Create under the given identity
+// create can be nil if the backend can't create new databases
+func Register(identity string, open OpenFunc, create CreateFunc) {
+	if open == nil {
+		panic("anydb: Register open is nil")
+	}
+	if _, dup := drivers[identity]; dup {
+		panic("anydb: Register called twice for " + identity)
+	}
+	drivers[identity] = driver{open: open, create: create}
+}
+
+// ErrNotSupported is returned when a backend doesn't implement an operation
+type ErrNotSupported struct {
+	Identity string
+	Op       string
+}
+
+func (e *ErrNotSupported) Error() string {
+	return fmt.Sprintf("%s not supported for %s", e.Op, e.Identity)
+}
+
+// IsNotSupported returns true if err is an ErrNotSupported
+func IsNotSupported(err error) bool {
+	_, ok := err.(*ErrNotSupported)
+	return ok
+}
+
+func notSupported(identity string, op string) error {
+	return &ErrNotSupported{Identity: identity, Op: op}
+}
+
 // ADB is the anydb struct
 type ADB struct {
 	identity string
 	path     string
+	backend  Backend
 
-	lastKey []byte
+	lastKey     []byte
+	valueOffset int
 
-	leveldb       *leveldb.DB
-	levelIterator iterator.Iterator
+	// iteration is bounded to keys in [start, end)
+	start []byte
+	end   []byte
+	valid bool
 
-	lmdb       lmdb.DBI
-	lmdbEnv    *lmdb.Env
-	lmdbTxn    *lmdb.Txn
-	lmdbCursor *lmdb.Cursor
-	//lmdbScanner *lmdbscan.Scanner
-	lmdbKey   []byte
-	lmdbValue []byte
+	// reverse makes Reset start at the last record and Next move backwards
+	reverse bool
 
-	folderFiles         []string
-	folderIterator      int
-	folderValue         []byte
-	folderValueIterator int
+	// rgb tells that Datums hold their channels in RGB order instead of caffe's BGR
+	rgb bool
 
-	fileHandle  *os.File
-	fileScanner *bufio.Scanner
-	fileKey     []byte
-	fileKeyCol  int
-	fileValue   []byte
-	fileLines   uint64
-
-	aerospikeClient    *aerospike.Client
-	aerospikeNamespace string
-	aerospikeSet       string
+	// codec reads values as floats, nil detects it from the first value read
+	codec    Codec
+	detected Codec
 
 	keyFilter [2]int
+
+	// only keys matching keyMatch are visited
+	keyMatch *regexp.Regexp
+	// returned keys are rewritten with keyPattern.ReplaceAll(key, keyReplacement)
+	keyPattern     *regexp.Regexp
+	keyReplacement []byte
+
+	// followKey is the last key read by Follow, backends go on from there
+	followKey []byte
+}
+
+// Backend returns the underlying backend of this db
+func (db *ADB) Backend() Backend {
+	return db.backend
+}
+
+// aerospike returns the aerospike backend or an ErrNotSupported
+func (db *ADB) aerospike(op string) (*aerospikeDB, error) {
+	as, ok := db.backend.(*aerospikeDB)
+	if !ok {
+		return nil, notSupported(db.identity, op)
+	}
+	return as, nil
 }
 
 // SetContext sets namespace and set for aerospike requests
 func (db *ADB) SetContext(namespace string, set string) (err error) {
-	if db.identity == "aerospike" {
-		db.aerospikeSet = set
-		db.aerospikeNamespace = namespace
+	as, err := db.aerospike("SetContext")
+	if err != nil {
 		return
 	}
-	return errors.New("Not supported")
+	as.namespace = namespace
+	as.set = set
+	return
 }
 
-// Entries returns estimated(?) number of entries
-func (db *ADB) Entries() (entries uint64) {
-
-	switch db.identity {
-	case "file":
-		if db.fileLines == 0 {
-			db.fileLines = getLineCount(db.path)
-		}
-		return db.fileLines
+// Buckets returns the names of all buckets in the db
+func (db *ADB) Buckets() ([]string, error) {
+	b, ok := db.backend.(BucketSetter)
+	if !ok {
+		return nil, notSupported(db.identity, "Buckets")
+	}
+	return b.Buckets()
+}
 
-	case "lmdb":
-		stat, _ := db.lmdbEnv.Stat()
-		entries = stat.Entries
-	case "folder":
-		entries = uint64(len(db.folderFiles))
+// SetBucket selects which bucket to read from and write to, and resets the iterator
+func (db *ADB) SetBucket(name string) error {
+	b, ok := db.backend.(BucketSetter)
+	if !ok {
+		return notSupported(db.identity, "SetBucket")
 	}
-	return
+	db.valueOffset = 0
+	err := b.SetBucket(name)
+	if err != nil {
+		return err
+	}
+	return db.Reset()
 }
 
-// Stat prints some lmdb stats
-func (db *ADB) Stat() {
-	switch db.identity {
-	case "lmdb":
-		stat, _ := db.lmdbEnv.Stat()
-		fmt.Printf("%+v\n", stat)
+// Bucket returns the name of the selected bucket
+func (db *ADB) Bucket() string {
+	b, ok := db.backend.(BucketSetter)
+	if !ok {
+		return ""
 	}
+	return b.Bucket()
+}
+
+// Entries returns the exact number of entries, 0 if unknown
+// See EstimateEntries for backends that don't keep count
+func (db *ADB) Entries() (entries uint64) {
+	return db.backend.Entries()
+}
 
+// Stat returns some internal stats of the db
+func (db *ADB) Stat() ([]Property, error) {
+	s, ok := db.backend.(Stater)
+	if !ok {
+		return nil, notSupported(db.identity, "Stat")
+	}
+	return s.Stat()
 }
 
 // GetRecord returns an aerospike record
 func (db *ADB) GetRecord(key []byte) (record *aerospike.Record, err error) {
-	switch db.identity {
-	case "aerospike":
-		var k *aerospike.Key
-		k, err = aerospike.NewKey(db.aerospikeNamespace, db.aerospikeSet, key)
-		if err != nil {
-			return
-		}
-		record, err = db.aerospikeClient.Get(nil, k)
-		if err != nil {
-			return
-		}
+	as, err := db.aerospike("GetRecord")
+	if err != nil {
 		return
-
-	default:
-		return nil, errors.New("Not supported")
 	}
+	return as.getRecord(key)
 }
 
 // GetRandom returns a random key value pair from the database
-// Only implemented for folder databases (typically a directory with image files)
+// With a key filter or a range (see SetRange) it tries randomFilterTries records to
+// find a key that passes both
 func (db *ADB) GetRandom() (key []byte, value []byte, err error) {
-	switch db.identity {
-	case "folder":
-		i := rand.Int63n(int64(len(db.folderFiles)) - 1)
-		key = []byte(db.folderFiles[i])
-		value, err = ioutil.ReadFile(filepath.Join(db.path, db.folderFiles[i]))
-	default:
-		return nil, nil, errors.New("not implemented for this db")
+	r, ok := db.backend.(RandomGetter)
+	if !ok {
+		return nil, nil, notSupported(db.identity, "GetRandom")
 	}
-	return
+	for i := 0; i < randomFilterTries; i++ {
+		key, value, err = r.GetRandom()
+		if err != nil || db.matches(key) && db.inRange(key) {
+			return
+		}
+	}
+	return nil, nil, errors.New("No random key matches the key filter and range")
 }
 
+// randomFilterTries is how many random records GetRandom draws to find one passing the key filter and range
+const randomFilterTries = 1000
+
 // Get returns the value of a key, without moving the iterator
 func (db *ADB) Get(k []byte) (key []byte, value []byte, err error) {
 	if bytes.Equal(k, []byte("last")) {
@@ -148,46 +308,148 @@ func (db *ADB) Get(k []byte) (key []byte, value []byte, err error) {
 	} else {
 		key = k
 	}
-	switch db.identity {
+	g, ok := db.backend.(Getter)
+	if !ok {
+		return key, nil, notSupported(db.identity, "Get")
+	}
+	value, err = g.Get(key)
+	return
+}
 
-	case "folder":
-		for i := range db.folderFiles {
-			if bytes.Compare(k, []byte(db.folderFiles[i])) == 0 {
-				value, err = ioutil.ReadFile(filepath.Join(db.path, db.folderFiles[i]))
-			}
+// Image returns a go Image parsed from the value of the current iterator
+// From a folder it tries to load the file as an Image, other DBs are expected to
+// hold caffe Datums. The two images of a 6 channel Datum are put side by side
+func (db *ADB) Image() (image image.Image, err error) {
+	i, ok := db.backend.(Imager)
+	if ok {
+		return i.Image()
+	}
+	return ValueImage(db.Value(), db.rgb)
+}
+
+// Images returns the images of the current value, two for a 6 channel Datum
+func (db *ADB) Images() ([]image.Image, error) {
+	i, ok := db.backend.(Imager)
+	if ok {
+		img, err := i.Image()
+		if err != nil {
+			return nil, err
 		}
+		return []image.Image{img}, nil
+	}
+	return ValueImages(db.Value(), db.rgb)
+}
+
+// SetCodec selects the codec of values by name, "auto" detects it from the values
+func (db *ADB) SetCodec(name string) error {
+	db.detected = nil
+	if name == "auto" {
+		db.codec = nil
+		return nil
+	}
+	c, err := CodecByName(name)
+	if err != nil {
+		return err
+	}
+	db.codec = c
+	return nil
+}
+
+// Codec returns the codec of values, detecting it from value if none is selected
+// yet. Values that don't reveal a codec are read as text
+func (db *ADB) Codec(value []byte) Codec {
+	if db.codec != nil {
+		return db.codec
+	}
+	if db.detected != nil {
+		return db.detected
+	}
+	c, err := DetectCodec(value)
+	if err != nil {
+		return codecs["text"]
+	}
+	db.detected = c
+	return c
+}
+
+// CodecName returns the name of the selected codec, or auto
+func (db *ADB) CodecName() string {
+	if db.codec != nil {
+		return db.codec.Name()
+	}
+	if db.detected != nil {
+		return "auto (" + db.detected.Name() + ")"
+	}
+	return "auto"
+}
 
-	case "leveldb":
-		value, err = db.leveldb.Get(key, nil)
+// Floats decodes a value of this db as a row of floats
+func (db *ADB) Floats(value []byte) ([]float64, error) {
+	return db.Codec(value).Floats(value)
+}
 
-	case "lmdb":
-		err = db.lmdbEnv.View(func(txn *lmdb.Txn) (err error) {
-			v, err := txn.Get(db.lmdb, key)
-			value = make([]byte, len(v))
-			copy(value, v)
+// EncodeFloats makes a value for this db of a row of floats
+func (db *ADB) EncodeFloats(f []float64) ([]byte, error) {
+	return db.writeCodec().Encode(f)
+}
 
-			return err
-		})
+// EncodeText makes a value for this db of text, which has to be a list of numbers
+// unless values are text
+func (db *ADB) EncodeText(s string) ([]byte, error) {
+	c := db.writeCodec()
+	if c.Name() == "text" {
+		return []byte(s), nil
+	}
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '[' || r == ']' || unicode.IsSpace(r)
+	})
+	f := make([]float64, len(fields))
+	for i, field := range fields {
+		var err error
+		f[i], err = strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Not a number: %v, values are %v", field, c.Name())
+		}
+	}
+	return c.Encode(f)
+}
 
+// writeCodec is the codec for new values, detected from the current record if none
+// is selected
+func (db *ADB) writeCodec() Codec {
+	var value []byte
+	if db.codec == nil && db.detected == nil && db.Valid() {
+		value = db.Value()
 	}
-	return
+	return db.Codec(value)
 }
 
-// Image returns a go Image parsed from the value of the current iterator
-// From a folder it tries to load the file as an Image
-// From a LMDB it extracts uint8 data for all channels from a caffe Datum
-// For other DBs it is undefined
-func (db *ADB) Image() (image image.Image, err error) {
-	switch db.identity {
-	case "lmdb":
+// SetRGB tells if Datums of this db hold their channels in RGB order, the default is BGR
+func (db *ADB) SetRGB(rgb bool) {
+	db.rgb = rgb
+}
 
-	case "folder":
-		image, err = imaging.Decode(bytes.NewReader(db.folderValue))
+// RGB returns true if Datums are read in RGB order
+func (db *ADB) RGB() bool {
+	return db.rgb
+}
 
-	default:
-		return nil, errors.New("No supported")
+// Classes returns the class names of a labelled db, a label is an index in this list
+func (db *ADB) Classes() ([]string, error) {
+	l, ok := db.backend.(Labeler)
+	if !ok {
+		return nil, notSupported(db.identity, "Classes")
 	}
-	return
+	return l.Classes(), nil
+}
+
+// LabelOf returns the class label of the record with the given key
+func (db *ADB) LabelOf(key []byte) (int32, error) {
+	l, ok := db.backend.(Labeler)
+	if !ok {
+		return 0, notSupported(db.identity, "LabelOf")
+	}
+	return l.LabelOf(key)
 }
 
 // Path returns path of this db
@@ -206,305 +468,385 @@ func (db *ADB) SetFilterRange(i int, j int) {
 	db.keyFilter[1] = j
 }
 
+// SetKeyFilter makes iteration skip keys that don't match re, nil turns it off
+// The iterator is reset
+func (db *ADB) SetKeyFilter(re *regexp.Regexp) error {
+	db.keyMatch = re
+	return db.Reset()
+}
+
+// KeyFilter returns the regex set by SetKeyFilter
+func (db *ADB) KeyFilter() *regexp.Regexp {
+	return db.keyMatch
+}
+
+// SetKeyMap rewrites the keys returned by Key with pattern.ReplaceAll(key, replacement),
+// nil turns it off
+func (db *ADB) SetKeyMap(pattern *regexp.Regexp, replacement string) {
+	db.keyPattern = pattern
+	db.keyReplacement = []byte(replacement)
+}
+
+// KeyMap returns the rule set by SetKeyMap
+func (db *ADB) KeyMap() (pattern *regexp.Regexp, replacement string) {
+	return db.keyPattern, string(db.keyReplacement)
+}
+
+// matches checks key against the key filter
+func (db *ADB) matches(key []byte) bool {
+	return db.keyMatch == nil || db.keyMatch.Match(key)
+}
+
+// skipFiltered moves on with move while the current key doesn't pass the key filter
+func (db *ADB) skipFiltered(move func() bool) {
+	for db.valid && !db.matches(db.backend.Key()) {
+		if !move() || !db.atRecord() || !db.inRange(db.backend.Key()) {
+			db.valid = false
+		}
+	}
+}
+
 // Release frees the iterator if there is one
 func (db *ADB) Release() {
-	if db.levelIterator != nil {
-		db.levelIterator.Release()
-		db.levelIterator = nil
+	db.backend.Release()
+}
+
+// Scan setups iterator/cursor if there is none.
+func (db *ADB) Scan() error {
+	db.valueOffset = 0
+	err := db.backend.Scan()
+	if err != nil {
+		db.valid = false
+		return err
+	}
+	if db.start != nil {
+		return db.Seek(db.start)
+	}
+	db.valid = db.atRecord() && db.inRange(db.backend.Key())
+	db.skipFiltered(db.backend.Next)
+	return nil
+}
+
+// Seek moves the cursor to the first key >= k
+func (db *ADB) Seek(k []byte) error {
+	s, ok := db.backend.(Seeker)
+	if !ok {
+		return notSupported(db.identity, "Seek")
 	}
-	if db.lmdbCursor != nil {
-		db.lmdbCursor.Close()
-		db.lmdbCursor = nil
+	db.valueOffset = 0
+	if db.start != nil && bytes.Compare(k, db.start) < 0 {
+		k = db.start
 	}
-	if db.lmdbTxn != nil {
-		db.lmdbTxn.Abort()
-		db.lmdbTxn = nil
+	err := s.Seek(k)
+	db.valid = err == nil && db.atRecord() && db.inRange(db.backend.Key())
+	db.skipFiltered(db.backend.Next)
+	return err
+}
+
+// Reset moves the cursor to the top, or to the start of the key range
+// In reverse mode it moves to the last record instead
+func (db *ADB) Reset() error {
+	if db.reverse {
+		return db.Last()
 	}
-	if db.fileHandle != nil {
-		db.fileHandle.Close()
+	db.valueOffset = 0
+	if db.start != nil {
+		return db.Seek(db.start)
 	}
+	err := db.backend.Reset()
+	db.valid = err == nil && db.atRecord() && db.inRange(db.backend.Key())
+	db.skipFiltered(db.backend.Next)
+	return err
 }
 
-// Scan setups iterator/cursor if there is none.
-func (db *ADB) Scan() {
-	switch db.identity {
-	case "file":
-		db.fileScanner = bufio.NewScanner(db.fileHandle)
-		db.Next()
-
-	case "leveldb":
-		if db.levelIterator == nil {
-			db.levelIterator = db.leveldb.NewIterator(nil, nil)
+// Last moves the cursor to the last record, or the end of the key range
+func (db *ADB) Last() (err error) {
+	r, ok := db.backend.(Reverser)
+	if !ok {
+		return notSupported(db.identity, "Last")
+	}
+	db.valueOffset = 0
+	if s, ok := db.backend.(Seeker); ok && db.end != nil {
+		// move to the first key after the range and step back
+		err = s.Seek(db.end)
+		if err == nil {
+			if db.atRecord() {
+				r.Prev()
+			} else {
+				err = r.Last()
+			}
 		}
+	} else {
+		err = r.Last()
+	}
+	db.valid = err == nil && db.atRecord() && db.inRange(db.backend.Key())
+	db.skipFiltered(r.Prev)
+	return
+}
 
-	case "lmdb":
-		var err error
-		if db.lmdbTxn == nil {
-			db.lmdbTxn, err = db.lmdbEnv.BeginTxn(nil, 0)
-			if err != nil {
-				fmt.Printf("Could not start transcation\n")
-				return
-			}
-			db.lmdbCursor, err = db.lmdbTxn.OpenCursor(db.lmdb)
-			if err != nil {
-				fmt.Printf("Could not open cursor\n")
-				return
-			}
-			db.Reset()
+// SetReverse turns reverse iteration on or off, and resets the iterator
+func (db *ADB) SetReverse(reverse bool) error {
+	if _, ok := db.backend.(Reverser); !ok && reverse {
+		return notSupported(db.identity, "Prev")
+	}
+	db.reverse = reverse
+	return db.Reset()
+}
+
+// Reverse returns true in reverse mode
+func (db *ADB) Reverse() bool {
+	return db.reverse
+}
+
+// SetRange bounds iteration to keys >= start and < end, and resets the iterator
+// A nil start or end means no bound in that direction
+func (db *ADB) SetRange(start []byte, end []byte) error {
+	if start != nil {
+		if _, ok := db.backend.(Seeker); !ok {
+			return notSupported(db.identity, "Seek")
 		}
 	}
+	db.start, db.end = start, end
+	return db.Reset()
+}
+
+// SetPrefix bounds iteration to keys starting with prefix
+func (db *ADB) SetPrefix(prefix []byte) error {
+	return db.SetRange(prefix, prefixEnd(prefix))
 }
 
-// Seek moves the cursor to k
-func (db *ADB) Seek(k []byte) {
-	switch db.identity {
-	case "lmdb":
-		//what about the key filter ?
-		db.lmdbCursor.Get(k, nil, lmdb.SetRange)
+// Range returns the bounds set by SetRange or SetPrefix
+func (db *ADB) Range() (start []byte, end []byte) {
+	return db.start, db.end
+}
+
+// Valid returns true if the iterator is at a record inside the key range
+func (db *ADB) Valid() bool {
+	return db.valid
+}
+
+// atRecord returns true if the backend iterator points at a record
+// (files might not have keys, so values are checked as well)
+func (db *ADB) atRecord() bool {
+	return db.backend.Key() != nil || db.backend.Value() != nil
+}
+
+// inRange checks key against the bounds, records without keys are always inside
+func (db *ADB) inRange(key []byte) bool {
+	if key == nil {
+		return true
+	}
+	if db.start != nil && bytes.Compare(key, db.start) < 0 {
+		return false
 	}
+	if db.end != nil && bytes.Compare(key, db.end) >= 0 {
+		return false
+	}
+	return true
 }
 
-// Reset moves the cursor to the top
-func (db *ADB) Reset() {
-	switch db.identity {
-	case "lmdb":
-		db.lmdbKey, db.lmdbValue, _ = db.lmdbCursor.Get(nil, nil, lmdb.First)
-	case "folder":
-		db.folderIterator = 0
-	case "file":
-		db.fileHandle.Seek(0, 0)
-		db.fileScanner = bufio.NewScanner(db.fileHandle)
-		db.Next()
+// prefixEnd returns the first key after all keys starting with prefix
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
 	}
+	// prefix is all 0xff, no upper bound
+	return nil
 }
 
-// Key returns key of current iterator
+// Key returns key of current iterator, rewritten by the key map and filter range
 func (db *ADB) Key() (key []byte) {
-	switch db.identity {
-	case "file":
-		key = db.fileKey
-	case "leveldb":
-		key = db.levelIterator.Key()
-	case "lmdb":
-		key = db.lmdbKey
-	case "folder":
-		key = []byte(db.folderFiles[db.folderIterator])
+	return db.MapKey(db.backend.Key())
+}
+
+// MapKey rewrites a key of this db the way Key does
+func (db *ADB) MapKey(key []byte) []byte {
+	if db.keyPattern != nil && key != nil {
+		key = db.keyPattern.ReplaceAll(key, db.keyReplacement)
 	}
 
 	//apply key filter
 	if db.keyFilter[0] != 0 || db.keyFilter[1] != 0 {
-		if db.keyFilter[1] == 0 || db.keyFilter[1] > len(key) {
-			key = key[db.keyFilter[0]:]
+		i := db.keyFilter[0]
+		if i > len(key) {
+			i = len(key)
+		}
+		if db.keyFilter[1] == 0 || db.keyFilter[1] >= len(key) {
+			key = key[i:]
+		} else if db.keyFilter[1] >= i {
+			key = key[i : db.keyFilter[1]+1]
 		} else {
-			key = key[db.keyFilter[0] : db.keyFilter[1]+1]
+			key = key[:0]
 		}
 	}
-	return
+	return key
 }
 
 // RadiusSearch searches an aerospike db using a geoindex
 func (db *ADB) RadiusSearch(namespace string, set string, bin string, lat, lng float64, radius float64) (*aerospike.Recordset, error) {
-	switch db.identity {
-	case "aerospike":
-		stm := aerospike.NewStatement(namespace, set)
-		stm.Addfilter(aerospike.NewGeoWithinRadiusFilter(bin, lng, lat, radius))
-		recordset, err := db.aerospikeClient.Query(nil, stm)
-		if err != nil {
-			return nil, err
-		}
-		return recordset, nil
-
-	default:
-		return nil, errors.New("Not supported")
+	as, err := db.aerospike("RadiusSearch")
+	if err != nil {
+		return nil, err
 	}
+	return as.radiusSearch(namespace, set, bin, lat, lng, radius)
 }
 
 // PutGeoJSON stores GeoJSON data in an aerospike namespace/set/bin
 func (db *ADB) PutGeoJSON(namespace string, set string, bin string, key []byte, json string) error {
-	switch db.identity {
-	case "aerospike":
-		asKey, err := aerospike.NewKey(namespace, set, key)
-		if err != nil {
-			return err
-		}
-		bin1 := aerospike.NewBin("key", string(key))
-		bin2 := aerospike.NewBin(bin, aerospike.NewGeoJSONValue(json))
-		err = db.aerospikeClient.PutBins(nil, asKey, bin1, bin2)
-		if err != nil {
-			return err
-		}
+	as, err := db.aerospike("PutGeoJSON")
+	if err != nil {
+		return err
 	}
-	return nil
+	return as.putGeoJSON(namespace, set, bin, key, json)
 }
 
-// Put ...
+// Put stores value under key
 func (db *ADB) Put(key []byte, value []byte) (err error) {
-	switch db.identity {
-	case "aerospike":
-	case "lmdb":
-		err = db.lmdbEnv.Update(func(txn *lmdb.Txn) (err error) {
-			err = txn.Put(db.lmdb, key, value, 0)
-			return err
-		})
-	default:
-		return errors.New("Currently not supported for this db")
+	p, ok := db.backend.(Putter)
+	if !ok {
+		return notSupported(db.identity, "Put")
 	}
-	return
+	return p.Put(key, value)
+}
+
+// Delete removes key from the db
+func (db *ADB) Delete(key []byte) (err error) {
+	d, ok := db.backend.(Deleter)
+	if !ok {
+		return notSupported(db.identity, "Delete")
+	}
+	return d.Delete(key)
 }
 
 // Read implements the io.Reader interface by reading the value at the current iterator
 func (db *ADB) Read(p []byte) (n int, err error) {
 	v := db.Value()
-	if len(p) > len(v)-db.folderValueIterator {
-		n = len(v) - db.folderValueIterator
-	} else {
-		n = len(p)
-	}
-	for i := 0; i < n; i++ {
-		p[i] = v[db.folderValueIterator]
-		db.folderValueIterator++
+	if db.valueOffset >= len(v) {
+		return 0, io.EOF
 	}
+	n = copy(p, v[db.valueOffset:])
+	db.valueOffset += n
 	return
 }
 
 // Value returns value of current iterator
 func (db *ADB) Value() (value []byte) {
-	switch db.identity {
-	case "folder":
-		if db.folderValue == nil {
-			db.folderValue, _ = ioutil.ReadFile(filepath.Join(db.path, db.folderFiles[db.folderIterator]))
-			db.folderValueIterator = 0
-		}
-		value = db.folderValue
-	case "file":
-		value = db.fileValue
-	case "leveldb":
-		value = db.levelIterator.Value()
-	case "lmdb":
-		value = db.lmdbValue
-	}
-	return
+	return db.backend.Value()
 }
 
-// Next returns the next record
+// Next moves to the next record, returns false at the end of the db or key range
+// In reverse mode it moves to the previous record
 func (db *ADB) Next() bool {
-	switch db.identity {
-	case "folder":
-		db.folderValue = nil
-		if db.folderIterator < len(db.folderFiles)-1 {
-			db.folderIterator++
-		} else {
-			return false
-		}
-	case "file":
-		db.fileScanner.Scan()
-		row := strings.Split(db.fileScanner.Text(), " ")
-		if db.fileKeyCol == -1 {
-			// try to detect location of key (if any) and values
-			for i := range row {
-				_, err := strconv.ParseFloat(row[i], 64)
-				if err != nil {
-					//not a float, assume it is the key
-					db.fileKeyCol = i
-					break
-				}
-			}
-		}
-		if db.fileKeyCol != -1 {
-			db.fileKey = []byte(row[db.fileKeyCol])
-			db.fileValue = []byte(strings.Join(append(row[0:db.fileKeyCol], row[db.fileKeyCol+1:]...), " "))
-		} else {
-			db.fileValue = []byte(strings.Join(row, " "))
-		}
+	if db.reverse {
+		return db.step(db.backend.(Reverser).Prev)
+	}
+	return db.step(db.backend.Next)
+}
 
-	case "leveldb":
-		return db.levelIterator.Next()
-	case "lmdb":
-		var err error
-		db.lmdbKey, db.lmdbValue, err = db.lmdbCursor.Get(nil, nil, lmdb.Next)
-		if lmdb.IsNotFound(err) {
-			return false
+// Prev moves to the previous record, returns false at the top of the db or key range
+// If the cursor has passed the end it moves to the last record
+func (db *ADB) Prev() bool {
+	r, ok := db.backend.(Reverser)
+	if !ok {
+		return false
+	}
+	if db.reverse {
+		return db.step(db.backend.Next)
+	}
+	if !db.valid {
+		return db.Last() == nil && db.valid
+	}
+	return db.step(r.Prev)
+}
+
+// Follow moves to the next record like Next. At the end of the db it looks for records
+// appended since and returns false while there are none, so it can be called again to
+// poll for more. Backends that aren't Followers only move on, reverse mode isn't followed
+func (db *ADB) Follow() bool {
+	if db.reverse {
+		return false
+	}
+	if db.valid {
+		// copy, lmdb keys are gone with the transaction Follow replaces
+		db.followKey = append(db.followKey[:0], db.backend.Key()...)
+		if db.Next() {
+			return true
 		}
-		db.lastKey = db.lmdbKey
 	}
+	f, ok := db.backend.(Follower)
+	if !ok {
+		return false
+	}
+	db.valueOffset = 0
+	db.valid = f.Follow(db.followKey) && db.atRecord() && db.inRange(db.backend.Key())
+	db.skipFiltered(db.backend.Next)
+	if !db.valid {
+		return false
+	}
+	db.lastKey = db.backend.Key()
+	return true
+}
+
+// step moves the backend iterator with move and checks the key range
+func (db *ADB) step(move func() bool) bool {
+	db.valueOffset = 0
+	if !db.valid || !move() || !db.inRange(db.backend.Key()) {
+		db.valid = false
+		return false
+	}
+	db.skipFiltered(move)
+	if !db.valid {
+		return false
+	}
+	db.lastKey = db.backend.Key()
 	return true
 }
 
 // Show returns info from aerospike node
 func (db *ADB) Show(info string) (r map[string]string, err error) {
-	if db.aerospikeClient != nil {
-		nodes := db.aerospikeClient.GetNodes()
-		r, err = aerospike.RequestNodeInfo(nodes[0], info)
-		return
+	as, err := db.aerospike("Show")
+	if err != nil {
+		return nil, errors.New("Sorry, only works with an aerospike db")
 	}
-	return nil, errors.New("Sorry, only works with an aerospike db")
+	return as.show(info)
 }
 
 // SizeOf returns approximate size of supplied key range
-func (db *ADB) SizeOf(start []byte, stop []byte) (size int64) {
-	switch db.identity {
-	case "leveldb":
-		var r util.Range
-		r.Start = start
-		r.Limit = stop
-		sizes, _ := db.leveldb.SizeOf([]util.Range{r})
-		size = sizes[0]
+func (db *ADB) SizeOf(start []byte, stop []byte) (size int64, err error) {
+	s, ok := db.backend.(Sizer)
+	if !ok {
+		return 0, notSupported(db.identity, "SizeOf")
 	}
-	return
+	return s.SizeOf(start, stop)
 }
 
 // Close closes it
 func (db *ADB) Close() {
-	if db.leveldb != nil {
-		db.leveldb.Close()
-	}
-	if db.lmdbEnv != nil {
-		db.lmdbEnv.Close()
-	}
-	if db.aerospikeClient != nil {
-		db.aerospikeClient.Close()
-	}
-	if db.fileHandle != nil {
-		db.fileHandle.Close()
-	}
+	db.backend.Close()
 }
 
 // Create sets up a new database at the given path
-// Only LMDB supported for now
 func Create(path string, dbType string) (db *ADB, err error) {
-	db = &ADB{}
-	switch dbType {
-	case "lmdb":
-		db.lmdbEnv, err = lmdb.NewEnv()
-		if err != nil {
-			return nil, err
-		}
-		db.lmdbEnv.SetMaxDBs(1)
-		db.lmdbEnv.SetMapSize(1 << 40)
-		err := os.MkdirAll(path, 0700)
-		if err != nil {
-			db.lmdbEnv.Close()
-			return nil, err
-		}
-		err = db.lmdbEnv.Open(path, lmdb.NoLock, 0644)
-		if err != nil {
-			db.lmdbEnv.Close()
-			return nil, err
-		}
-		err = db.lmdbEnv.Update(func(txn *lmdb.Txn) (err error) {
-			db.lmdb, err = txn.OpenRoot(lmdb.Create)
-			return err
-		})
-		if err != nil {
-			db.lmdbEnv.Close()
-			return nil, err
-		}
-		db.path = path
-		db.identity = "lmdb"
+	return CreateWith(path, dbType, nil)
+}
 
-	default:
+// CreateWith is Create with backend options
+func CreateWith(path string, dbType string, o Options) (db *ADB, err error) {
+	d, ok := drivers[dbType]
+	if !ok {
 		return nil, errors.New("No such db")
-
+	}
+	if d.create == nil {
+		return nil, notSupported(dbType, "Create")
+	}
+	db = &ADB{identity: dbType, path: path}
+	db.backend, err = d.create(path, o)
+	if err != nil {
+		return nil, err
 	}
 	return
 }
@@ -512,73 +854,31 @@ func Create(path string, dbType string) (db *ADB, err error) {
 // Open opens a database located at the supplied path (could be file or directory or server)
 // With empty dbType is will guess
 func Open(path string, dbType string) (db *ADB, err error) {
+	return OpenWith(path, dbType, nil)
+}
+
+// OpenWith is Open with backend options, like readonly for lmdb
+func OpenWith(path string, dbType string, o Options) (db *ADB, err error) {
 	db = &ADB{}
 
 	if dbType != "" {
 		db.identity, db.path = dbType, path
 	} else {
 		db.identity, db.path = guessDBType(path)
+		if db.identity == "file" && hasCSVOptions(o) {
+			db.identity = "csv"
+		}
 	}
 
 	//now open it
-	switch db.identity {
-	case "aerospike":
-		policy := aerospike.NewClientPolicy()
-		policy.Timeout = 5000 * time.Millisecond
-		db.aerospikeClient, err = aerospike.NewClientWithPolicy(policy, db.path, 3000)
-		if err == nil {
-			return db, nil
-		}
-
-	case "file":
-		db.fileKeyCol = -1
-		db.fileHandle, err = os.Open(db.path)
-		if err != nil {
-			return nil, err
-		}
-
-	case "folder":
-		// this can take a LONG time when opening large directories
-		fmt.Printf("Scanning folder...")
-		f, err := os.Open(db.path)
-		if err != nil {
-			return nil, err
-		}
-		db.folderFiles, err = f.Readdirnames(-1)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Printf("Done\n")
-
-	case "lmdb":
-		// by default LMDB are with NoLock, we could however try first without it like caffe does...
-		db.lmdbEnv, err = lmdb.NewEnv()
-		if err != nil {
-			return nil, err
-		}
-		err = db.lmdbEnv.Open(db.path, lmdb.NoLock, 0644)
-		if err != nil {
-			db.lmdbEnv.Close()
-			return nil, err
-		}
-		db.lmdbEnv.SetMaxDBs(1)
-		err = db.lmdbEnv.Update(func(txn *lmdb.Txn) (err error) {
-			db.lmdb, err = txn.OpenRoot(0)
-			return err
-		})
-
-	case "leveldb":
-		var options opt.Options
-		options.ErrorIfMissing = true
-		db.leveldb, err = leveldb.OpenFile(db.path, &options)
-
-	case "bolt":
-		err = fmt.Errorf("bolt unsupported")
-
-	case "unknown":
-		err = errors.New("No such db")
+	d, ok := drivers[db.identity]
+	if !ok {
+		return nil, errors.New("No such db")
+	}
+	db.backend, err = d.open(db.path, o)
+	if err != nil {
+		return nil, err
 	}
-
 	return
 }
 
@@ -609,15 +909,31 @@ func guessDBType(path string) (string, string) {
 			if err == nil {
 				return "leveldb", path
 			}
+			if isImageFolder(path) {
+				return "imagefolder", path
+			}
 			return "folder", path
 		}
+		if isDelimited(path) {
+			return "csv", path
+		}
+		if CompressionOf(path) != "" {
+			return "file", path
+		}
+		if isBolt(path) {
+			return "bolt", path
+		}
 		return "file", path
 	}
 	return "unknown", path
 }
 
+// getLineCount counts the lines of a text file, compressed ones are decompressed
 func getLineCount(path string) uint64 {
-	f, _ := os.Open(path)
+	f, err := openText(path)
+	if err != nil {
+		return 0
+	}
 	scanner := bufio.NewScanner(f)
 	var count uint64
 	for scanner.Scan() {
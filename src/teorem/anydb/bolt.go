@@ -0,0 +1,374 @@
+package anydb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+func init() {
+	Register("bolt", openBolt, createBolt)
+}
+
+// boltMagic is found at offset 16 of the first meta page in every bolt file
+const boltMagic = 0xED0CDAED
+
+// defaultBucket is used when creating a new bolt db
+const defaultBucket = "default"
+
+// boltDB is a bolt file. Records are read from and written to one bucket at a time
+type boltDB struct {
+	db     *bolt.DB
+	bucket []byte
+	tx     *bolt.Tx
+	cursor *bolt.Cursor
+	key    []byte
+	value  []byte
+	// db belongs to the boltDB this cursor was made from
+	shared bool
+	// number of records of the bucket, counting walks all its pages so it is kept
+	// until the next write
+	entries      uint64
+	entriesValid bool
+}
+
+// openBolt locks the file for writing, with the readonly option it takes a shared lock
+// instead so a db held by another reader can be inspected
+func openBolt(path string, o Options) (Backend, error) {
+	bdb, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: o.Bool("readonly")})
+	if err != nil {
+		return nil, err
+	}
+	db := &boltDB{db: bdb}
+
+	// start in the first bucket found
+	buckets, err := db.Buckets()
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+	if len(buckets) > 0 {
+		db.bucket = []byte(buckets[0])
+	}
+	return db, nil
+}
+
+func createBolt(path string, o Options) (Backend, error) {
+	bdb, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(defaultBucket))
+		return err
+	})
+	if err != nil {
+		bdb.Close()
+		return nil, err
+	}
+	return &boltDB{db: bdb, bucket: []byte(defaultBucket)}, nil
+}
+
+// isBolt checks the magic number of the file at path
+func isBolt(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 20)
+	_, err = f.ReadAt(header, 0)
+	if err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(header[16:20]) == boltMagic
+}
+
+// Buckets returns the names of all top level buckets
+func (db *boltDB) Buckets() (buckets []string, err error) {
+	err = db.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			buckets = append(buckets, string(name))
+			return nil
+		})
+	})
+	return
+}
+
+// SetBucket selects the bucket used for reads and writes, and resets the iterator
+func (db *boltDB) SetBucket(name string) error {
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(name)) == nil {
+			return fmt.Errorf("No such bucket: %s", name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	db.Release()
+	db.entriesValid = false
+	db.bucket = []byte(name)
+	return db.Scan()
+}
+
+// Bucket returns name of the selected bucket
+func (db *boltDB) Bucket() string {
+	return string(db.bucket)
+}
+
+func (db *boltDB) Scan() (err error) {
+	if db.tx != nil {
+		return
+	}
+	db.tx, err = db.db.Begin(false)
+	if err != nil {
+		return fmt.Errorf("Could not start transaction: %v", err)
+	}
+	b := db.tx.Bucket(db.bucket)
+	if b == nil {
+		// no bucket selected, or an empty db
+		return
+	}
+	db.cursor = b.Cursor()
+	return db.Reset()
+}
+
+func (db *boltDB) Reset() error {
+	if db.tx == nil {
+		return db.Scan()
+	}
+	if db.cursor != nil {
+		db.key, db.value = db.cursor.First()
+	}
+	return nil
+}
+
+func (db *boltDB) Seek(k []byte) error {
+	if db.tx == nil {
+		err := db.Scan()
+		if err != nil {
+			return err
+		}
+	}
+	if db.cursor == nil {
+		// no bucket selected, or an empty db
+		db.key, db.value = nil, nil
+		return nil
+	}
+	db.key, db.value = db.cursor.Seek(k)
+	return nil
+}
+
+func (db *boltDB) Next() bool {
+	if db.cursor == nil {
+		return false
+	}
+	db.key, db.value = db.cursor.Next()
+	return db.key != nil
+}
+
+func (db *boltDB) Last() error {
+	if db.tx == nil {
+		err := db.Scan()
+		if err != nil {
+			return err
+		}
+	}
+	if db.cursor != nil {
+		db.key, db.value = db.cursor.Last()
+	}
+	return nil
+}
+
+func (db *boltDB) Prev() bool {
+	if db.cursor == nil {
+		return false
+	}
+	db.key, db.value = db.cursor.Prev()
+	return db.key != nil
+}
+
+func (db *boltDB) Key() []byte {
+	return db.key
+}
+
+func (db *boltDB) Value() []byte {
+	return db.value
+}
+
+// GetRandom picks a record by seeking down its key one byte at a time, see
+// randomByDescent
+func (db *boltDB) GetRandom() (key []byte, value []byte, err error) {
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.bucket)
+		if b == nil {
+			return errors.New("No bucket selected")
+		}
+		c := b.Cursor()
+		k := randomByDescent(func(k []byte) []byte {
+			found, _ := c.Seek(k)
+			return found
+		})
+		if k == nil {
+			return errors.New("Empty db")
+		}
+		k, v := c.Seek(k)
+		key = append([]byte{}, k...)
+		value = append([]byte{}, v...)
+		return nil
+	})
+	return
+}
+
+func (db *boltDB) Get(key []byte) (value []byte, err error) {
+	err = db.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(db.bucket)
+		if b == nil {
+			return errors.New("No bucket selected")
+		}
+		v := b.Get(key)
+		if v == nil {
+			return errors.New("Not found")
+		}
+		value = make([]byte, len(v))
+		copy(value, v)
+		return nil
+	})
+	return
+}
+
+// Put writes to the selected bucket
+func (db *boltDB) Put(key []byte, value []byte) error {
+	return db.update(func(b *bolt.Bucket) error {
+		return b.Put(key, value)
+	})
+}
+
+// Delete removes key from the selected bucket
+func (db *boltDB) Delete(key []byte) error {
+	return db.update(func(b *bolt.Bucket) error {
+		return b.Delete(key)
+	})
+}
+
+// WriteBatch applies all ops in one write transaction
+func (db *boltDB) WriteBatch(ops []BatchOp) error {
+	return db.update(func(b *bolt.Bucket) error {
+		for _, op := range ops {
+			var err error
+			if op.Delete {
+				err = b.Delete(op.Key)
+			} else {
+				err = b.Put(op.Key, op.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// update runs fn on the selected bucket in a write transaction
+// The read transaction of the iterator is closed during the write, since
+// bolt can deadlock when it needs to grow the file with readers open
+func (db *boltDB) update(fn func(b *bolt.Bucket) error) error {
+	var current []byte
+	db.entriesValid = false
+	reopen := db.tx != nil
+	if reopen {
+		current = append([]byte(nil), db.key...)
+		db.Release()
+	}
+	err := db.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(db.bucket)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+	if reopen {
+		db.Scan()
+		if current != nil {
+			db.Seek(current)
+		}
+	}
+	return err
+}
+
+// Entries counts the records of the selected bucket once, until the next write
+func (db *boltDB) Entries() uint64 {
+	if db.entriesValid {
+		return db.entries
+	}
+	db.db.View(func(tx *bolt.Tx) error {
+		db.entries = 0
+		b := tx.Bucket(db.bucket)
+		if b != nil {
+			db.entries = uint64(b.Stats().KeyN)
+		}
+		db.entriesValid = true
+		return nil
+	})
+	return db.entries
+}
+
+func (db *boltDB) Stat() ([]Property, error) {
+	buckets, err := db.Buckets()
+	if err != nil {
+		return nil, err
+	}
+	p := []Property{
+		{"buckets", strings.Join(buckets, ", ")},
+		{"selected bucket", string(db.bucket)},
+	}
+	err = db.db.View(func(tx *bolt.Tx) error {
+		p = append(p, Property{"page size", fmt.Sprintf("%v bytes", tx.DB().Info().PageSize)})
+		p = append(p, Property{"data size", fmt.Sprintf("%v bytes", tx.Size())})
+		b := tx.Bucket(db.bucket)
+		if b != nil {
+			s := b.Stats()
+			p = append(p,
+				Property{"tree depth", fmt.Sprint(s.Depth)},
+				Property{"branch pages", fmt.Sprintf("%v (%v bytes in use)", s.BranchPageN, s.BranchInuse)},
+				Property{"leaf pages", fmt.Sprintf("%v (%v bytes in use)", s.LeafPageN, s.LeafInuse)},
+				Property{"overflow pages", fmt.Sprint(s.BranchOverflowN + s.LeafOverflowN)},
+			)
+		}
+		return nil
+	})
+	s := db.db.Stats()
+	p = append(p,
+		Property{"free pages", fmt.Sprintf("%v (%v bytes)", s.FreePageN, s.FreeAlloc)},
+		Property{"pending pages", fmt.Sprint(s.PendingPageN)},
+	)
+	return p, err
+}
+
+func (db *boltDB) Release() {
+	if db.tx != nil {
+		db.tx.Rollback()
+		db.tx = nil
+		db.cursor = nil
+		db.key, db.value = nil, nil
+	}
+}
+
+func (db *boltDB) Close() error {
+	db.Release()
+	if db.shared {
+		return nil
+	}
+	return db.db.Close()
+}
+
+// NewCursor returns an iterator with a read transaction of its own on the same bucket
+func (db *boltDB) NewCursor() (Backend, error) {
+	return &boltDB{db: db.db, bucket: db.bucket, shared: true}, nil
+}
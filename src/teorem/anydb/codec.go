@@ -0,0 +1,332 @@
+package anydb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"teorem/grappler/caffe"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/vmihailenco/msgpack"
+)
+
+// Codec reads and writes values as rows of floats
+type Codec interface {
+	Name() string
+	// Floats decodes a value
+	Floats(value []byte) ([]float64, error)
+	// Encode makes a value of a row of floats
+	Encode(f []float64) ([]byte, error)
+	// Format returns a value in a readable form
+	Format(value []byte) string
+}
+
+var codecs = map[string]Codec{
+	"datum":   datumCodec{},
+	"float32": float32Codec{},
+	"float64": float64Codec{},
+	"json":    jsonCodec{},
+	"msgpack": msgpackCodec{},
+	"text":    textCodec{},
+}
+
+// Codecs returns the names of all codecs
+func Codecs() []string {
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CodecByName returns a codec, see Codecs for the names
+func CodecByName(name string) (Codec, error) {
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown codec %v, use one of %v", name, strings.Join(Codecs(), ", "))
+	}
+	return c, nil
+}
+
+// DetectCodec guesses the codec of a value, text and JSON are recognized first, then
+// caffe Datums, MessagePack arrays and raw floats. Raw floats are float32 unless their
+// values only make sense as float64
+func DetectCodec(value []byte) (Codec, error) {
+	if len(value) == 0 {
+		return nil, errors.New("Can't detect the codec of an empty value")
+	}
+	if isText(value) {
+		var f []float64
+		if value[0] == '[' && json.Unmarshal(value, &f) == nil {
+			return codecs["json"], nil
+		}
+		return codecs["text"], nil
+	}
+	d := &caffe.Datum{}
+	if proto.Unmarshal(value, d) == nil && (d.GetEncoded() || d.GetChannels() > 0 || len(d.GetFloatData()) > 0) {
+		return codecs["datum"], nil
+	}
+	if value[0] >= 0x90 && value[0] <= 0x9f || value[0] == 0xdc || value[0] == 0xdd {
+		if _, err := (msgpackCodec{}).Floats(value); err == nil {
+			return codecs["msgpack"], nil
+		}
+	}
+	if len(value)%4 != 0 {
+		return nil, errors.New("Unknown value encoding")
+	}
+	if len(value)%8 == 0 {
+		f32, _ := (float32Codec{}).Floats(value)
+		f64, _ := (float64Codec{}).Floats(value)
+		// round float64 values have zero low words, which read as float32 zeros
+		zeros := 0
+		for i := 0; i < len(f32); i += 2 {
+			if f32[i] == 0 && f32[i+1] != 0 {
+				zeros++
+			}
+		}
+		if plausibleFloats(f64) && (!plausibleFloats(f32) || zeros == len(f32)/2) {
+			return codecs["float64"], nil
+		}
+	}
+	return codecs["float32"], nil
+}
+
+// isText is true for valid UTF-8 without control characters other than white space
+func isText(value []byte) bool {
+	if !utf8.Valid(value) {
+		return false
+	}
+	for _, r := range string(value) {
+		if unicode.IsControl(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// plausibleFloats is false if f holds NaNs, infinities or absurd magnitudes, which
+// is what floats of the wrong width look like
+func plausibleFloats(f []float64) bool {
+	for _, v := range f {
+		a := math.Abs(v)
+		if math.IsNaN(v) || math.IsInf(v, 0) || (a != 0 && (a < 1e-20 || a > 1e20)) {
+			return false
+		}
+	}
+	return true
+}
+
+// datumCodec reads the float_data of a caffe Datum, or its bytes for images
+type datumCodec struct{}
+
+func (datumCodec) Name() string {
+	return "datum"
+}
+
+func (datumCodec) Floats(value []byte) ([]float64, error) {
+	d, err := DecodeDatum(value)
+	if err != nil {
+		return nil, err
+	}
+	floats := d.GetFloatData()
+	if len(floats) == 0 {
+		// image Datums only have bytes
+		f64 := make([]float64, len(d.GetData()))
+		for i, v := range d.GetData() {
+			f64[i] = float64(v)
+		}
+		return f64, nil
+	}
+	f64 := make([]float64, len(floats))
+	for i, v := range floats {
+		f64[i] = float64(v)
+	}
+	return f64, nil
+}
+
+// Encode writes a 1x1xN Datum with float_data
+func (datumCodec) Encode(f []float64) ([]byte, error) {
+	floats := make([]float32, len(f))
+	for i, v := range f {
+		floats[i] = float32(v)
+	}
+	return proto.Marshal(&caffe.Datum{
+		Channels:  proto.Int32(1),
+		Height:    proto.Int32(1),
+		Width:     proto.Int32(int32(len(f))),
+		FloatData: floats,
+	})
+}
+
+func (datumCodec) Format(value []byte) string {
+	d := &caffe.Datum{}
+	err := proto.Unmarshal(value, d)
+	if err != nil {
+		return fmt.Sprintf("Not a caffe.Datum: %v", err)
+	}
+	return fmt.Sprintf("Datum %vx%vx%v, label %v, encoded %v, %v bytes, %v floats",
+		d.GetChannels(), d.GetHeight(), d.GetWidth(), d.GetLabel(), d.GetEncoded(), len(d.GetData()), len(d.GetFloatData()))
+}
+
+// float32Codec reads raw little endian float32 arrays
+type float32Codec struct{}
+
+func (float32Codec) Name() string {
+	return "float32"
+}
+
+func (float32Codec) Floats(value []byte) ([]float64, error) {
+	if len(value)%4 != 0 {
+		return nil, fmt.Errorf("%v bytes is not a float32 array", len(value))
+	}
+	f := make([]float64, len(value)/4)
+	for i := range f {
+		f[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(value[i*4:])))
+	}
+	return f, nil
+}
+
+func (float32Codec) Encode(f []float64) ([]byte, error) {
+	value := make([]byte, len(f)*4)
+	for i, v := range f {
+		binary.LittleEndian.PutUint32(value[i*4:], math.Float32bits(float32(v)))
+	}
+	return value, nil
+}
+
+func (c float32Codec) Format(value []byte) string {
+	return formatCodecFloats(c, value)
+}
+
+// float64Codec reads raw little endian float64 arrays
+type float64Codec struct{}
+
+func (float64Codec) Name() string {
+	return "float64"
+}
+
+func (float64Codec) Floats(value []byte) ([]float64, error) {
+	if len(value)%8 != 0 {
+		return nil, fmt.Errorf("%v bytes is not a float64 array", len(value))
+	}
+	f := make([]float64, len(value)/8)
+	for i := range f {
+		f[i] = math.Float64frombits(binary.LittleEndian.Uint64(value[i*8:]))
+	}
+	return f, nil
+}
+
+func (float64Codec) Encode(f []float64) ([]byte, error) {
+	value := make([]byte, len(f)*8)
+	for i, v := range f {
+		binary.LittleEndian.PutUint64(value[i*8:], math.Float64bits(v))
+	}
+	return value, nil
+}
+
+func (c float64Codec) Format(value []byte) string {
+	return formatCodecFloats(c, value)
+}
+
+// jsonCodec reads JSON arrays of numbers
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+func (jsonCodec) Floats(value []byte) ([]float64, error) {
+	var f []float64
+	err := json.Unmarshal(value, &f)
+	return f, err
+}
+
+func (jsonCodec) Encode(f []float64) ([]byte, error) {
+	return json.Marshal(f)
+}
+
+func (jsonCodec) Format(value []byte) string {
+	return string(value)
+}
+
+// msgpackCodec reads MessagePack arrays of numbers
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return "msgpack"
+}
+
+func (msgpackCodec) Floats(value []byte) ([]float64, error) {
+	var f []float64
+	err := msgpack.Unmarshal(value, &f)
+	return f, err
+}
+
+func (msgpackCodec) Encode(f []float64) ([]byte, error) {
+	return msgpack.Marshal(f)
+}
+
+func (msgpackCodec) Format(value []byte) string {
+	var v interface{}
+	err := msgpack.Unmarshal(value, &v)
+	if err != nil {
+		return fmt.Sprintf("Not MessagePack: %v", err)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// textCodec reads numbers separated by spaces, tabs or commas, the format of file
+// databases. Words that are not numbers are NaN
+type textCodec struct{}
+
+func (textCodec) Name() string {
+	return "text"
+}
+
+func (textCodec) Floats(value []byte) ([]float64, error) {
+	fields := strings.FieldsFunc(string(value), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	f := make([]float64, len(fields))
+	for i, field := range fields {
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			v = math.NaN()
+		}
+		f[i] = v
+	}
+	return f, nil
+}
+
+func (textCodec) Encode(f []float64) ([]byte, error) {
+	var b bytes.Buffer
+	for i, v := range f {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	return b.Bytes(), nil
+}
+
+func (textCodec) Format(value []byte) string {
+	return string(value)
+}
+
+// formatCodecFloats shows the floats of a value, or why they can't be read
+func formatCodecFloats(c Codec, value []byte) string {
+	f, err := c.Floats(value)
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprint(f)
+}
@@ -0,0 +1,78 @@
+package anydb
+
+import (
+	"reflect"
+	"teorem/grappler/caffe"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	// exact in float32, so every codec gives them back unchanged
+	rows := [][]float64{
+		{1},
+		{1, -2.5, 0.125, 1e6},
+		{0, 0, 3},
+	}
+	for _, name := range Codecs() {
+		c, err := CodecByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			value, err := c.Encode(row)
+			if err != nil {
+				t.Errorf("%v: encoding %v: %v", name, row, err)
+				continue
+			}
+			f, err := c.Floats(value)
+			if err != nil || !reflect.DeepEqual(f, row) {
+				t.Errorf("%v: %v came back as %v, %v", name, row, f, err)
+			}
+		}
+	}
+	if _, err := CodecByName("xml"); err == nil {
+		t.Errorf("unknown codec: no error")
+	}
+}
+
+func TestDetectCodec(t *testing.T) {
+	encode := func(name string, f ...float64) []byte {
+		value, err := codecs[name].Encode(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	image, _ := proto.Marshal(&caffe.Datum{Encoded: proto.Bool(true), Data: []byte{0xff, 0xd8, 0xff}})
+	tests := []struct {
+		value []byte
+		// "" for an error
+		want string
+	}{
+		{[]byte("1 2 3"), "text"},
+		{[]byte("a,b"), "text"},
+		{[]byte("[1, 2.5]"), "json"},
+		{[]byte("[1, a]"), "text"},
+		{encode("datum", 1, 2, 3), "datum"},
+		{image, "datum"},
+		{encode("msgpack", 1, 2, 3), "msgpack"},
+		{encode("float32", 1, 2, 3), "float32"},
+		{encode("float32", 1.5, 2.5, 3.5, 4.5), "float32"},
+		{encode("float64", 1, 2), "float64"},
+		{encode("float64", 0.1, -3.7, 1e5), "float64"},
+		{nil, ""},
+		{[]byte{0x80, 0x81, 0x82}, ""},
+	}
+	for _, test := range tests {
+		c, err := DetectCodec(test.value)
+		name := ""
+		if err == nil {
+			name = c.Name()
+		}
+		if name != test.want {
+			t.Errorf("% x: got %q (%v), want %q", test.value, name, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package anydb
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressions of text files, as returned by CompressionOf
+const (
+	Gzip  = "gzip"
+	Bzip2 = "bzip2"
+	Zstd  = "zstd"
+)
+
+var compressionMagic = []struct {
+	compression string
+	magic       []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Bzip2, []byte("BZh")},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// CompressionOf tells how the file at path is compressed, "" if it isn't. The magic
+// bytes decide, the extension is only used for empty or missing files
+func CompressionOf(path string) string {
+	f, err := os.Open(path)
+	if err == nil {
+		header := make([]byte, 4)
+		n, _ := io.ReadFull(f, header)
+		f.Close()
+		if n > 0 {
+			for _, c := range compressionMagic {
+				if bytes.HasPrefix(header[:n], c.magic) {
+					return c.compression
+				}
+			}
+			return ""
+		}
+	}
+	return CompressionByExt(path)
+}
+
+// CompressionByExt tells the compression of a file from its extension: .gz, .bz2 or .zst
+func CompressionByExt(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gz", ".gzip":
+		return Gzip
+	case ".bz2", ".bzip2":
+		return Bzip2
+	case ".zst", ".zstd":
+		return Zstd
+	}
+	return ""
+}
+
+// decompressor reads the uncompressed data of r, Close frees the decoder but doesn't
+// close r
+func decompressor(r io.Reader, compression string) (io.ReadCloser, error) {
+	switch compression {
+	case "":
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Bzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	case Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, errors.New("Unknown compression " + compression)
+}
+
+// Compressor compresses what is written to w, Close flushes it but doesn't close w
+// Concatenated gzip or zstd streams read as one, so files can be appended to
+func Compressor(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "":
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Bzip2:
+		return nil, errors.New("Writing bzip2 is not supported, use .gz or .zst")
+	case Zstd:
+		return zstd.NewWriter(w)
+	}
+	return nil, errors.New("Unknown compression " + compression)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// textReader closes the decompressor and then the file
+type textReader struct {
+	io.ReadCloser
+	file *os.File
+}
+
+func (r textReader) Close() error {
+	r.ReadCloser.Close()
+	return r.file.Close()
+}
+
+// openText opens the file at path for reading its text, decompressed if it has to be
+func openText(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		// a new file, nothing to decompress
+		return f, nil
+	}
+	r, err := decompressor(f, CompressionOf(path))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return textReader{r, f}, nil
+}
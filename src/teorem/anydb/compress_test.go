@@ -0,0 +1,144 @@
+package anydb
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompressionOf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name string
+		// nil for no file
+		content []byte
+		want    string
+	}{
+		{"plain.txt", []byte("a 1\n"), ""},
+		{"new.gz", []byte{}, Gzip},
+		{"new.bz2", []byte{}, Bzip2},
+		{"new.zst", []byte{}, Zstd},
+		{"missing.gz", nil, Gzip},
+		// magic bytes win over the extension
+		{"gzip.txt", []byte{0x1f, 0x8b, 8, 0}, Gzip},
+		{"bzip2", []byte("BZh91AY"), Bzip2},
+		{"zstd.dat", []byte{0x28, 0xb5, 0x2f, 0xfd, 0}, Zstd},
+		{"text.gz", []byte("a 1\n"), ""},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if test.content != nil {
+			if err := ioutil.WriteFile(path, test.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if c := CompressionOf(path); c != test.want {
+			t.Errorf("%v: got %q, want %q", test.name, c, test.want)
+		}
+	}
+}
+
+// gzipMembers counts the gzip streams of the file at path
+func gzipMembers(t *testing.T, path string) (n int) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	z, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		z.Multistream(false)
+		if _, err = io.Copy(ioutil.Discard, z); err != nil {
+			t.Fatal(err)
+		}
+		n++
+		if err = z.Reset(r); err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Every batch of a Writer is appended to compressed files as one stream
+func TestWriteBatchStreams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		name   string
+		dbType string
+		// gzip streams expected, 0 to not count them
+		streams int
+	}{
+		{"lines.txt.gz", "file", 3},
+		{"lines.txt.zst", "file", 0},
+		{"lines.txt", "file", 0},
+		{"rows.csv.gz", "csv", 3},
+		{"rows.csv.zst", "csv", 0},
+	}
+	const records = 12
+	var want []string
+	for i := 0; i < records; i++ {
+		want = append(want, fmt.Sprintf("k%02d", i))
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		db, err := Create(path, test.dbType)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		w := db.NewWriter(5, 0)
+		for _, k := range want {
+			if err = w.Put([]byte(k), []byte("1 2")); err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		db.Close()
+
+		if test.streams != 0 {
+			if n := gzipMembers(t, path); n != test.streams {
+				t.Errorf("%v: %v streams, want %v", test.name, n, test.streams)
+			}
+		}
+		db, err = Open(path, test.dbType)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		var keys []string
+		db.Scan()
+		db.Reset()
+		for db.Valid() {
+			keys = append(keys, string(db.Key()))
+			if v, _ := db.Floats(db.Value()); !reflect.DeepEqual(v, []float64{1, 2}) {
+				t.Errorf("%v: %s has values %v", test.name, db.Key(), v)
+			}
+			if !db.Next() {
+				break
+			}
+		}
+		db.Close()
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("%v: read %v, want %v", test.name, keys, want)
+		}
+	}
+}
@@ -0,0 +1,619 @@
+package anydb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+func init() {
+	Register("csv", openCSV, createCSV)
+}
+
+// csvSampleLines is the number of lines read to detect delimiter, header and key column
+const csvSampleLines = 20
+
+// csvDelimiters are tried in this order when detecting the delimiter
+var csvDelimiters = []rune{',', '\t', ';', '|'}
+
+// csvDB is a CSV or TSV file, plain or compressed, with one record per row
+// The value of a record are its value columns separated by spaces, fields that are
+// missing or not numbers are NaN
+type csvDB struct {
+	path        string
+	compression string
+	in          io.ReadCloser
+	read        func() ([]string, error)
+
+	// delimiter separates fields, 0 for runs of white space
+	delimiter rune
+	header    []string
+	// keyCol is -1 for files without keys, valueCols nil for all columns but the key
+	keyCol    int
+	valueCols []int
+
+	key   []byte
+	value []byte
+	rows  uint64
+}
+
+// openCSV reads the first lines to detect what isn't given by the options:
+// delimiter=<char> | tab | space, header or noheader, key=<column> | none and
+// values=<column>,<column>,... where columns are names from the header, indices
+// counted from 1 or ranges of indices like 2-129
+func openCSV(path string, o Options) (Backend, error) {
+	db := &csvDB{path: path, compression: CompressionOf(path), keyCol: -1}
+	lines, err := csvSample(path)
+	if err != nil {
+		return nil, err
+	}
+
+	db.delimiter, err = csvDelimiter(o.Get("delimiter", "auto"), lines)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 && o.Get("delimiter", "auto") == "auto" {
+		// nothing to detect in a new file
+		db.delimiter = ','
+		if strings.Contains(strings.ToLower(filepath.Base(path)), ".tsv") {
+			db.delimiter = '\t'
+		}
+	}
+	var rows [][]string
+	for _, l := range lines {
+		if row := db.split(l); len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	header := len(rows) > 1 && csvIsHeader(rows[0], rows[1])
+	if _, ok := o["header"]; ok {
+		header = o.Bool("header")
+	}
+	if o.Bool("noheader") {
+		header = false
+	}
+	if header && len(rows) > 0 {
+		db.header = rows[0]
+		rows = rows[1:]
+	}
+
+	switch key := o.Get("key", "auto"); key {
+	case "auto":
+		// the first column that isn't a number, like plain files
+		if len(rows) > 0 {
+			for i, f := range rows[0] {
+				if !csvMissing(f) && !csvNumber(f) {
+					db.keyCol = i
+					break
+				}
+			}
+		}
+	case "none":
+	default:
+		db.keyCol, err = db.column(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if values := o.Get("values", ""); values != "" {
+		db.valueCols = []int{}
+		for _, v := range strings.Split(values, ",") {
+			cols, err := db.columns(v)
+			if err != nil {
+				return nil, err
+			}
+			db.valueCols = append(db.valueCols, cols...)
+		}
+	}
+
+	err = db.Reset()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// createCSV opens the file at path, creating an empty one if missing
+// New files have the key in the first column
+func createCSV(path string, o Options) (Backend, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if _, ok := o["key"]; !ok {
+		o = copyOptions(o)
+		o["key"] = "1"
+	}
+	return openCSV(path, o)
+}
+
+// copyOptions returns a copy of o that can be changed
+func copyOptions(o Options) Options {
+	c := make(Options)
+	for k, v := range o {
+		c[k] = v
+	}
+	return c
+}
+
+// hasCSVOptions is true if o sets up columns, text files are read as csv then
+func hasCSVOptions(o Options) bool {
+	for _, name := range []string{"delimiter", "header", "noheader", "key", "values"} {
+		if _, ok := o[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isDelimited is true for files named .csv or .tsv, compressed or not
+func isDelimited(path string) bool {
+	if CompressionByExt(path) != "" {
+		path = strings.TrimSuffix(path, filepath.Ext(path))
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv", ".tsv":
+		return true
+	}
+	return false
+}
+
+// csvSample returns the first non empty lines of the file at path
+func csvSample(path string) ([]string, error) {
+	f, err := openText(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for len(lines) < csvSampleLines && scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) != "" {
+			lines = append(lines, scanner.Text())
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// csvDelimiter returns the delimiter named by the option, or detects it from lines as
+// the candidate giving the most fields, the same number on every line. Without one
+// fields are separated by white space
+func csvDelimiter(name string, lines []string) (rune, error) {
+	switch name {
+	case "auto":
+	case "tab", "\\t":
+		return '\t', nil
+	case "space", "whitespace":
+		return 0, nil
+	case "comma":
+		return ',', nil
+	case "semicolon":
+		return ';', nil
+	default:
+		if utf8.RuneCountInString(name) != 1 {
+			return 0, fmt.Errorf("Malformed delimiter %v, use one character, tab or space", name)
+		}
+		r, _ := utf8.DecodeRuneInString(name)
+		return r, nil
+	}
+
+	var best rune
+	bestFields := 1
+	for _, d := range csvDelimiters {
+		c := &csvDB{delimiter: d}
+		fields := -1
+		for _, l := range lines {
+			n := len(c.split(l))
+			if fields == -1 {
+				fields = n
+			} else if n != fields {
+				fields = 0
+				break
+			}
+		}
+		if fields > bestFields {
+			best, bestFields = d, fields
+		}
+	}
+	return best, nil
+}
+
+// csvIsHeader is true if a column has a name in first and a number in second
+func csvIsHeader(first []string, second []string) bool {
+	for i, f := range first {
+		if i < len(second) && !csvMissing(f) && !csvNumber(f) && csvNumber(second[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// csvMissing is true for empty fields and the usual markers of missing values
+func csvMissing(field string) bool {
+	switch strings.ToLower(strings.TrimSpace(field)) {
+	case "", "na", "n/a", "nan", "null", "none", "-", "?":
+		return true
+	}
+	return false
+}
+
+func csvNumber(field string) bool {
+	_, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+	return err == nil
+}
+
+// column returns the index of a column given by header name or index counted from 1
+func (db *csvDB) column(name string) (int, error) {
+	for i, h := range db.header {
+		if h == name {
+			return i, nil
+		}
+	}
+	i, err := strconv.Atoi(name)
+	if err != nil || i < 1 {
+		return 0, fmt.Errorf("No such column: %v", name)
+	}
+	return i - 1, nil
+}
+
+// columns returns the indices of a column or a range of indices like 2-129
+func (db *csvDB) columns(name string) ([]int, error) {
+	if i, err := db.column(name); err == nil {
+		return []int{i}, nil
+	}
+	bounds := strings.SplitN(name, "-", 2)
+	if len(bounds) != 2 {
+		return nil, fmt.Errorf("No such column: %v", name)
+	}
+	from, err := db.column(bounds[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := db.column(bounds[1])
+	if err != nil {
+		return nil, err
+	}
+	if to < from {
+		return nil, fmt.Errorf("Empty column range: %v", name)
+	}
+	var cols []int
+	for i := from; i <= to; i++ {
+		cols = append(cols, i)
+	}
+	return cols, nil
+}
+
+// split parses a single line, used for the sample
+func (db *csvDB) split(line string) []string {
+	if db.delimiter == 0 {
+		return strings.Fields(line)
+	}
+	r := db.reader(strings.NewReader(line))
+	row, _ := r.Read()
+	return row
+}
+
+func (db *csvDB) reader(in io.Reader) *csv.Reader {
+	r := csv.NewReader(in)
+	r.Comma = db.delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	// trimming would merge empty fields between tabs
+	r.TrimLeadingSpace = !unicode.IsSpace(db.delimiter)
+	return r
+}
+
+// open starts reading rows from the top, skipping the header
+func (db *csvDB) open() error {
+	if db.in != nil {
+		db.in.Close()
+	}
+	in, err := openText(db.path)
+	if err != nil {
+		db.in, db.read = nil, nil
+		return err
+	}
+	db.in = in
+	if db.delimiter == 0 {
+		scanner := bufio.NewScanner(in)
+		db.read = func() ([]string, error) {
+			for scanner.Scan() {
+				if row := strings.Fields(scanner.Text()); len(row) > 0 {
+					return row, nil
+				}
+			}
+			if scanner.Err() != nil {
+				return nil, scanner.Err()
+			}
+			return nil, io.EOF
+		}
+	} else {
+		db.read = db.reader(in).Read
+	}
+	if db.header != nil {
+		db.readRow()
+	}
+	return nil
+}
+
+// readRow returns the next row, rows that can't be parsed are skipped
+func (db *csvDB) readRow() ([]string, error) {
+	for {
+		row, err := db.read()
+		if _, bad := err.(*csv.ParseError); !bad {
+			return row, err
+		}
+	}
+}
+
+func (db *csvDB) Scan() error {
+	if db.read == nil {
+		return db.Reset()
+	}
+	return nil
+}
+
+// Reset opens the file again, compressed files are decompressed from the start
+func (db *csvDB) Reset() error {
+	err := db.open()
+	if err != nil {
+		return err
+	}
+	db.Next()
+	return nil
+}
+
+func (db *csvDB) Next() bool {
+	if db.read == nil {
+		return false
+	}
+	row, err := db.readRow()
+	if err != nil {
+		db.key, db.value = nil, nil
+		return false
+	}
+	db.key, db.value = db.record(row)
+	return true
+}
+
+// record makes key and value of a row
+func (db *csvDB) record(row []string) (key []byte, value []byte) {
+	if db.keyCol >= 0 && db.keyCol < len(row) {
+		key = []byte(row[db.keyCol])
+	}
+	var b bytes.Buffer
+	add := func(i int) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		if i >= len(row) || csvMissing(row[i]) || !csvNumber(row[i]) {
+			b.WriteString("NaN")
+		} else {
+			b.WriteString(strings.TrimSpace(row[i]))
+		}
+	}
+	if db.valueCols != nil {
+		for _, i := range db.valueCols {
+			add(i)
+		}
+	} else {
+		n := len(row)
+		if len(db.header) > n {
+			n = len(db.header)
+		}
+		for i := 0; i < n; i++ {
+			if i != db.keyCol {
+				add(i)
+			}
+		}
+	}
+	return key, b.Bytes()
+}
+
+// Seek moves to the first row with key >= k, counting from the top
+// This only makes sense for files sorted by key
+func (db *csvDB) Seek(k []byte) error {
+	err := db.Reset()
+	if err != nil {
+		return err
+	}
+	for db.key != nil && bytes.Compare(db.key, k) < 0 {
+		db.Next()
+	}
+	return nil
+}
+
+func (db *csvDB) Key() []byte {
+	return db.key
+}
+
+func (db *csvDB) Value() []byte {
+	return db.value
+}
+
+// Put appends a row, the space separated numbers of value go to the value columns
+func (db *csvDB) Put(key []byte, value []byte) error {
+	return db.WriteBatch([]BatchOp{{Key: key, Value: value}})
+}
+
+// WriteBatch appends the rows of all puts, to a compressed file as one stream
+// Rows can't be deleted
+func (db *csvDB) WriteBatch(ops []BatchOp) error {
+	for _, op := range ops {
+		if op.Delete {
+			return notSupported("csv", "Delete")
+		}
+	}
+
+	f, err := os.OpenFile(db.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	cw, err := Compressor(f, db.compression)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if db.delimiter == 0 {
+		w := bufio.NewWriter(cw)
+		for _, op := range ops {
+			_, err = w.WriteString(strings.Join(db.row(op.Key, op.Value), " ") + "\n")
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+	} else {
+		w := csv.NewWriter(cw)
+		w.Comma = db.delimiter
+		for _, op := range ops {
+			w.Write(db.row(op.Key, op.Value))
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	if err == nil {
+		err = cw.Close()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if db.rows != 0 {
+		db.rows += uint64(len(ops))
+	}
+	return f.Close()
+}
+
+// row places key in the key column and the space separated numbers of value in the
+// value columns, or around the key when there are no value columns
+func (db *csvDB) row(key []byte, value []byte) []string {
+	fields := strings.Fields(string(value))
+	if db.valueCols != nil && len(fields) <= len(db.valueCols) {
+		n := db.keyCol + 1
+		for _, i := range db.valueCols {
+			if i >= n {
+				n = i + 1
+			}
+		}
+		row := make([]string, n)
+		for j, f := range fields {
+			row[db.valueCols[j]] = f
+		}
+		if db.keyCol >= 0 {
+			row[db.keyCol] = string(key)
+		}
+		return row
+	}
+	if db.keyCol >= 0 && db.keyCol <= len(fields) {
+		return append(fields[:db.keyCol], append([]string{string(key)}, fields[db.keyCol:]...)...)
+	}
+	return fields
+}
+
+// Entries counts the rows once, without the header
+func (db *csvDB) Entries() uint64 {
+	if db.rows != 0 {
+		return db.rows
+	}
+	c := &csvDB{path: db.path, delimiter: db.delimiter, header: db.header}
+	if c.open() != nil {
+		return 0
+	}
+	defer c.in.Close()
+	for {
+		if _, err := c.readRow(); err != nil {
+			break
+		}
+		db.rows++
+	}
+	return db.rows
+}
+
+func (db *csvDB) Stat() ([]Property, error) {
+	delimiter := "white space"
+	switch db.delimiter {
+	case 0:
+	case '\t':
+		delimiter = "tab"
+	default:
+		delimiter = string(db.delimiter)
+	}
+	key := "none"
+	if db.keyCol >= 0 {
+		key = db.columnName(db.keyCol)
+	}
+	values := "all others"
+	if db.valueCols != nil {
+		names := make([]string, len(db.valueCols))
+		for i, c := range db.valueCols {
+			names[i] = db.columnName(c)
+		}
+		values = strings.Join(names, ", ")
+	}
+	header := "none"
+	if db.header != nil {
+		header = strings.Join(db.header, ", ")
+	}
+	compression := db.compression
+	if compression == "" {
+		compression = "none"
+	}
+	return []Property{
+		{"rows", strconv.FormatUint(db.Entries(), 10)},
+		{"delimiter", delimiter},
+		{"header", header},
+		{"key column", key},
+		{"value columns", values},
+		{"compression", compression},
+	}, nil
+}
+
+// columnName returns the header name of a column, or its index counted from 1
+func (db *csvDB) columnName(i int) string {
+	if i < len(db.header) {
+		return db.header[i]
+	}
+	return strconv.Itoa(i + 1)
+}
+
+func (db *csvDB) Release() {
+}
+
+func (db *csvDB) Close() error {
+	if db.in != nil {
+		return db.in.Close()
+	}
+	return nil
+}
+
+// NewCursor opens the file again with the same columns
+func (db *csvDB) NewCursor() (Backend, error) {
+	c := &csvDB{
+		path:        db.path,
+		compression: db.compression,
+		delimiter:   db.delimiter,
+		header:      db.header,
+		keyCol:      db.keyCol,
+		valueCols:   db.valueCols,
+		rows:        db.rows,
+	}
+	err := c.Reset()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
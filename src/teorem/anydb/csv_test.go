@@ -0,0 +1,139 @@
+package anydb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVDelimiter(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  rune
+		ok    bool
+	}{
+		{"auto", []string{"a,b,c", "1,2,3"}, ',', true},
+		{"auto", []string{"a;b;c", "1;2,5;3"}, ';', true},
+		{"auto", []string{"a\tb\t\tc", "1\t2\t\t3"}, '\t', true},
+		{"auto", []string{"a b c", "1 2 3"}, 0, true},
+		{"auto", []string{`"x,y",b`, "1,2"}, ',', true},
+		// the field count has to be the same on every line
+		{"auto", []string{"a,b", "1,2,3"}, 0, true},
+		{"auto", nil, 0, true},
+		{"tab", nil, '\t', true},
+		{"space", []string{"a,b"}, 0, true},
+		{"semicolon", nil, ';', true},
+		{"|", []string{"a,b"}, '|', true},
+		{"ab", nil, 0, false},
+	}
+	for _, test := range tests {
+		d, err := csvDelimiter(test.name, test.lines)
+		if (err == nil) != test.ok || d != test.want {
+			t.Errorf("%v %q: got %q, %v", test.name, test.lines, d, err)
+		}
+	}
+}
+
+func TestCSVIsHeader(t *testing.T) {
+	tests := []struct {
+		first  string
+		second string
+		want   bool
+	}{
+		{"id x y", "a 1 2", true},
+		{"x y", "1 2", true},
+		{"a b", "c d", false},
+		{"1 2", "3 4", false},
+		{"NA x", "1 NA", false},
+		{"x", "", false},
+	}
+	for _, test := range tests {
+		if h := csvIsHeader(strings.Fields(test.first), strings.Fields(test.second)); h != test.want {
+			t.Errorf("%q over %q: got %v", test.first, test.second, h)
+		}
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	db := &csvDB{header: []string{"id", "x", "y", "z", "1"}}
+	tests := []struct {
+		name string
+		want []int
+	}{
+		{"id", []int{0}},
+		{"y", []int{2}},
+		{"3", []int{2}},
+		// names go before indices
+		{"1", []int{4}},
+		{"x-z", []int{1, 2, 3}},
+		{"2-3", []int{1, 2}},
+		{"x-4", []int{1, 2, 3}},
+		{"z-x", nil},
+		{"w", nil},
+		{"0", nil},
+		{"a-b-c", nil},
+	}
+	for _, test := range tests {
+		cols, err := db.columns(test.name)
+		if (err == nil) != (test.want != nil) || !reflect.DeepEqual(cols, test.want) {
+			t.Errorf("%q: got %v, %v", test.name, cols, err)
+		}
+	}
+}
+
+func TestOpenCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		content string
+		options Options
+		// key and value of every row, space separated
+		want []string
+	}{
+		{"id,x,y\nr1,1,2\nr2,3,4\n", nil, []string{"r1 1 2", "r2 3 4"}},
+		{"id,x,y,label\nr1,1,,cat\nr2,NA,4,dog\n", nil, []string{"r1 1 NaN NaN", "r2 NaN 4 NaN"}},
+		{"id,x,y,label\nr1,1,2,cat\n", Options{"values": "x-y"}, []string{"r1 1 2"}},
+		{"id,x,y,label\nr1,1,2,cat\n", Options{"values": "y,x", "key": "label"}, []string{"cat 2 1"}},
+		{"1,2,k1\n3,4,k2\n", nil, []string{"k1 1 2", "k2 3 4"}},
+		{"1,2,3\n4,5,6\n", Options{"key": "none"}, []string{" 1 2 3", " 4 5 6"}},
+		// a header that doesn't look like one
+		{"a,b\nc,1\n", Options{"header": "", "key": "a"}, []string{"c 1"}},
+		// the key is the first column that isn't a number in the first row
+		{"x,y\n1,2\n", Options{"noheader": ""}, []string{"x NaN", "1 2"}},
+		// decimal commas aren't numbers
+		{"k;1,5;2\n", nil, []string{"k NaN 2"}},
+		{"k\t\t2\n", Options{"delimiter": "tab"}, []string{"k NaN 2"}},
+		{"k  1   2\n", nil, []string{"k 1 2"}},
+	}
+	for n, test := range tests {
+		path := filepath.Join(dir, string('a'+rune(n))+".csv")
+		if err = ioutil.WriteFile(path, []byte(test.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		db, err := OpenWith(path, "csv", test.options)
+		if err != nil {
+			t.Errorf("%q %v: %v", test.content, test.options, err)
+			continue
+		}
+		var rows []string
+		db.Scan()
+		db.Reset()
+		for db.Valid() {
+			rows = append(rows, string(db.Key())+" "+string(db.Value()))
+			if !db.Next() {
+				break
+			}
+		}
+		db.Close()
+		if !reflect.DeepEqual(rows, test.want) {
+			t.Errorf("%q %v: got %q, want %q", test.content, test.options, rows, test.want)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package anydb
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+)
+
+// Cursorer is implemented by backends that can iterate with several cursors at once
+// NewCursor returns a Backend on the same storage with an iterator of its own, its
+// Close only frees that iterator
+type Cursorer interface {
+	NewCursor() (Backend, error)
+}
+
+// Cursor iterates over a db without moving the iterator of the db or of other cursors
+// Cursors of one db can be used from different goroutines, one goroutine per cursor
+// Key range, key filters, key map, codec and channel order are copied from the db when
+// the cursor is made
+type Cursor struct {
+	ADB
+}
+
+// NewCursor makes a cursor at the first record of the key range, or at the last in
+// reverse mode. Close it when done, that doesn't close the db
+func (db *ADB) NewCursor() (*Cursor, error) {
+	cr, ok := db.backend.(Cursorer)
+	if !ok {
+		return nil, notSupported(db.identity, "NewCursor")
+	}
+	b, err := cr.NewCursor()
+	if err != nil {
+		return nil, err
+	}
+	c := &Cursor{ADB: *db}
+	c.backend = b
+	c.lastKey, c.valueOffset, c.valid = nil, 0, false
+	err = c.Scan()
+	if err == nil && c.reverse {
+		err = c.Last()
+	}
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Shards splits the key range of db into up to n cursors over consecutive key ranges
+// Split keys are spread evenly between the first and the last key, which balances the
+// shards for keys like the sequential ids caffe writes. Backends that can't seek or
+// iterate backwards give a single cursor. Cursors iterate forward, in key order
+func (db *ADB) Shards(n int) ([]*Cursor, error) {
+	first, err := db.NewCursor()
+	if err != nil {
+		return nil, err
+	}
+	first.reverse = false
+	err = first.Reset()
+	if err != nil {
+		first.Close()
+		return nil, err
+	}
+	if n < 2 || !first.Valid() {
+		return []*Cursor{first}, nil
+	}
+	_, seeker := first.backend.(Seeker)
+	_, reverser := first.backend.(Reverser)
+	if !seeker || !reverser {
+		return []*Cursor{first}, nil
+	}
+	from := append([]byte(nil), first.backend.Key()...)
+	err = first.Last()
+	if err != nil {
+		first.Close()
+		return nil, err
+	}
+	to := append([]byte(nil), first.backend.Key()...)
+
+	splits := splitKeys(from, to, n)
+	shards := []*Cursor{first}
+	for range splits {
+		c, err := db.NewCursor()
+		if err != nil {
+			for _, s := range shards {
+				s.Close()
+			}
+			return nil, err
+		}
+		c.reverse = false
+		shards = append(shards, c)
+	}
+	// shard i reads [splits[i-1], splits[i]), the outer bounds are those of db
+	for i, c := range shards {
+		if i > 0 {
+			c.start = splits[i-1]
+		}
+		if i < len(splits) {
+			c.end = splits[i]
+		}
+		err = c.Reset()
+		if err != nil {
+			for _, s := range shards {
+				s.Close()
+			}
+			return nil, err
+		}
+	}
+	return shards, nil
+}
+
+// splitKeys returns up to n-1 increasing keys that split [first, last] into n parts
+// of the same size. Keys are read as big endian numbers padded to the same length
+func splitKeys(first []byte, last []byte, n int) (splits [][]byte) {
+	if bytes.Compare(first, last) >= 0 {
+		return nil
+	}
+	size := len(first)
+	if len(last) > size {
+		size = len(last)
+	}
+	a := new(big.Int).SetBytes(pad(first, size))
+	b := new(big.Int).SetBytes(pad(last, size))
+	span := new(big.Int).Sub(b, a)
+	prev := first
+	for i := 1; i < n; i++ {
+		k := new(big.Int).Mul(span, big.NewInt(int64(i)))
+		k.Div(k, big.NewInt(int64(n)))
+		k.Add(k, a)
+		key := make([]byte, size)
+		kb := k.Bytes()
+		copy(key[size-len(kb):], kb)
+		// narrow key spaces give the same split more than once
+		if bytes.Compare(key, prev) > 0 {
+			splits = append(splits, key)
+			prev = key
+		}
+	}
+	return
+}
+
+// pad right pads key with zeros to n bytes
+func pad(key []byte, n int) []byte {
+	p := make([]byte, n)
+	copy(p, key)
+	return p
+}
+
+// ErrStopScan can be returned by the function given to ParallelScan to stop all shards
+// without failing the scan
+var ErrStopScan = errors.New("Scan stopped")
+
+// ParallelScan calls fn for every record of db, the key range is split into n shards
+// (see Shards) read by one goroutine each. fn gets the number of the shard and its
+// cursor at the record, fn must not move it. An error returned by fn stops all shards,
+// the first one is returned unless it is ErrStopScan
+func (db *ADB) ParallelScan(n int, fn func(shard int, c *Cursor) error) error {
+	shards, err := db.Shards(n)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	var once sync.Once
+	var stop int32
+	for i, c := range shards {
+		wg.Add(1)
+		go func(i int, c *Cursor) {
+			defer wg.Done()
+			defer c.Close()
+			for c.Valid() && atomic.LoadInt32(&stop) == 0 {
+				if e := fn(i, c); e != nil {
+					once.Do(func() { err = e })
+					atomic.StoreInt32(&stop, 1)
+					return
+				}
+				if !c.Next() {
+					return
+				}
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	if err == ErrStopScan {
+		return nil
+	}
+	return err
+}
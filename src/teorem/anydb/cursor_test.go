@@ -0,0 +1,99 @@
+package anydb
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSplitKeys(t *testing.T) {
+	tests := []struct {
+		first string
+		last  string
+		n     int
+		want  []string
+	}{
+		{"a", "c", 2, []string{"b"}},
+		{"00000000", "00009999", 2, []string{"00004\xb4\xb4\xb4"}},
+		{"0", "9", 3, []string{"3", "6"}},
+		// keys are padded with zeros to the same length
+		{"a", "bb", 2, []string{"a\xb1"}},
+		// narrow key spaces give fewer splits
+		{"a", "b", 4, nil},
+		{"a", "a", 2, nil},
+		{"b", "a", 2, nil},
+		{"a", "z", 1, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, k := range splitKeys([]byte(test.first), []byte(test.last), test.n) {
+			got = append(got, string(k))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q-%q in %v: got %q, want %q", test.first, test.last, test.n, got, test.want)
+		}
+	}
+}
+
+func TestParallelScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := Create(filepath.Join(dir, "scan"), "leveldb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	const records = 1000
+	for i := 0; i < records; i++ {
+		err = db.Put([]byte(fmt.Sprintf("%04d", i)), []byte("v"))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	failed := errors.New("failed")
+	tests := []struct {
+		// fn returns err at key
+		key  string
+		err  error
+		want error
+	}{
+		{"", nil, nil},
+		{"0500", failed, failed},
+		{"0999", failed, failed},
+		{"0000", ErrStopScan, nil},
+	}
+	for _, test := range tests {
+		var mutex sync.Mutex
+		seen := make(map[string]int)
+		err = db.ParallelScan(4, func(shard int, c *Cursor) error {
+			key := string(c.Key())
+			mutex.Lock()
+			seen[key]++
+			mutex.Unlock()
+			if key == test.key {
+				return test.err
+			}
+			return nil
+		})
+		if err != test.want {
+			t.Errorf("error at %q: got %v, want %v", test.key, err, test.want)
+		}
+		for k, n := range seen {
+			if n != 1 {
+				t.Errorf("error at %q: %v read %v times", test.key, k, n)
+			}
+		}
+		if test.err == nil && len(seen) != records {
+			t.Errorf("read %v of %v records", len(seen), records)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package anydb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"teorem/grappler/caffe"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/protobuf/proto"
+)
+
+// DecodeDatum unmarshals a caffe Datum, an encoded image in it is decoded to raw pixels
+func DecodeDatum(value []byte) (*caffe.Datum, error) {
+	d := &caffe.Datum{}
+	err := proto.Unmarshal(value, d)
+	if err != nil {
+		return nil, err
+	}
+	err = DecodeDatumImage(d)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// DecodeDatumImage replaces the JPEG or PNG data of an encoded Datum, as written by
+// convert_imageset --encoded, with C x H x W bytes in BGR order like caffe decodes them
+// Channels, height and width are filled in, gray images get one channel
+func DecodeDatumImage(d *caffe.Datum) error {
+	if !d.GetEncoded() {
+		return nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(d.GetData()))
+	if err != nil {
+		return err
+	}
+	channels := 3
+	if m := img.ColorModel(); m == color.GrayModel || m == color.Gray16Model {
+		channels = 1
+	}
+	nrgba := imaging.Clone(img)
+	w, h := nrgba.Bounds().Dx(), nrgba.Bounds().Dy()
+	size := w * h
+	data := make([]byte, channels*size)
+	for i := 0; i < size; i++ {
+		if channels == 1 {
+			data[i] = nrgba.Pix[i*4]
+		} else {
+			data[i], data[size+i], data[2*size+i] = nrgba.Pix[i*4+2], nrgba.Pix[i*4+1], nrgba.Pix[i*4]
+		}
+	}
+	d.Data = data
+	d.Channels = proto.Int32(int32(channels))
+	d.Height = proto.Int32(int32(h))
+	d.Width = proto.Int32(int32(w))
+	d.Encoded = proto.Bool(false)
+	return nil
+}
+
+// ValueImages returns the images stored in a value: a caffe Datum with 1 or 3 channels
+// gives one image and a 6 channel siamese Datum two. Values that are not Datums are
+// decoded as image files. rgb tells the channel order of Datums, caffe uses BGR
+func ValueImages(value []byte, rgb bool) ([]image.Image, error) {
+	d := &caffe.Datum{}
+	if proto.Unmarshal(value, d) != nil || (!d.GetEncoded() && d.GetChannels() == 0) {
+		img, err := imaging.Decode(bytes.NewReader(value))
+		if err != nil {
+			return nil, errors.New("Not an image or a caffe.Datum")
+		}
+		return []image.Image{img}, nil
+	}
+	return DatumImages(d, rgb)
+}
+
+// ValueImage is ValueImages with the images of a siamese Datum put side by side
+func ValueImage(value []byte, rgb bool) (image.Image, error) {
+	images, err := ValueImages(value, rgb)
+	if err != nil {
+		return nil, err
+	}
+	return joinImages(images), nil
+}
+
+// DatumImages turns the uint8 or float data of a Datum into images
+// Float data is clamped to 0-255, or scaled up if it is all within 0-1
+func DatumImages(d *caffe.Datum, rgb bool) ([]image.Image, error) {
+	err := DecodeDatumImage(d)
+	if err != nil {
+		return nil, err
+	}
+	c, h, w := int(d.GetChannels()), int(d.GetHeight()), int(d.GetWidth())
+	size := w * h
+	pixels := datumPixels(d)
+	if size == 0 || len(pixels) != c*size {
+		return nil, fmt.Errorf("Datum has %v values, expected %vx%vx%v", len(pixels), c, h, w)
+	}
+
+	switch c {
+	case 1:
+		img := image.NewGray(image.Rect(0, 0, w, h))
+		copy(img.Pix, pixels)
+		return []image.Image{img}, nil
+	case 3, 6:
+		images := make([]image.Image, c/3)
+		for n := range images {
+			img := image.NewNRGBA(image.Rect(0, 0, w, h))
+			planes := pixels[n*3*size : (n+1)*3*size]
+			r, g, b := planes[2*size:], planes[size:], planes[:size]
+			if rgb {
+				r, b = b, r
+			}
+			for i := 0; i < size; i++ {
+				img.Pix[i*4], img.Pix[i*4+1], img.Pix[i*4+2], img.Pix[i*4+3] = r[i], g[i], b[i], 255
+			}
+			images[n] = img
+		}
+		return images, nil
+	}
+	return nil, fmt.Errorf("Can't make an image of %v channels", c)
+}
+
+// datumPixels returns the data of a Datum as bytes
+func datumPixels(d *caffe.Datum) []byte {
+	floats := d.GetFloatData()
+	if len(floats) == 0 {
+		return d.GetData()
+	}
+	scale := float32(255)
+	for _, f := range floats {
+		if f < 0 || f > 1 {
+			scale = 1
+			break
+		}
+	}
+	pixels := make([]byte, len(floats))
+	for i, f := range floats {
+		f *= scale
+		switch {
+		case f < 0:
+			pixels[i] = 0
+		case f > 255:
+			pixels[i] = 255
+		default:
+			pixels[i] = byte(f + 0.5)
+		}
+	}
+	return pixels
+}
+
+// joinImages puts images side by side
+func joinImages(images []image.Image) image.Image {
+	if len(images) == 1 {
+		return images[0]
+	}
+	var w, h int
+	for _, img := range images {
+		w += img.Bounds().Dx()
+		if img.Bounds().Dy() > h {
+			h = img.Bounds().Dy()
+		}
+	}
+	joined := imaging.New(w, h, color.White)
+	x := 0
+	for _, img := range images {
+		joined = imaging.Paste(joined, img, image.Pt(x, 0))
+		x += img.Bounds().Dx()
+	}
+	return joined
+}
@@ -0,0 +1,431 @@
+package anydb
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("file", openFile, createFile)
+}
+
+// fileDB is a text file with one record per line, gzip, bzip2 and zstd compressed files
+// are decompressed while reading
+type fileDB struct {
+	path    string
+	handle  *os.File
+	scanner *bufio.Scanner
+	key     []byte
+	keyCol  int
+	value   []byte
+	lines   uint64
+	// offset is the end of the last complete line read
+	offset int64
+	// following leaves a last line without newline for Follow to read when it is done
+	following bool
+	// compression is "" for plain text, stream decompresses handle otherwise
+	compression string
+	stream      io.ReadCloser
+}
+
+func openFile(path string, o Options) (Backend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	db := &fileDB{path: path, handle: f, keyCol: -1, compression: CompressionOf(path)}
+	err = db.openStream()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openStream starts decompressing a compressed file from the top
+func (db *fileDB) openStream() error {
+	if db.stream != nil {
+		db.stream.Close()
+		db.stream = nil
+	}
+	if db.compression == "" {
+		return nil
+	}
+	_, err := db.handle.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+	info, err := db.handle.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		// a new file, nothing to decompress yet
+		return nil
+	}
+	db.stream, err = decompressor(db.handle, db.compression)
+	return err
+}
+
+// input returns what lines are read from
+func (db *fileDB) input() io.Reader {
+	if db.stream != nil {
+		return db.stream
+	}
+	return db.handle
+}
+
+// createFile opens the file at path, creating an empty one if missing
+// Records put in files named .gz or .zst are compressed
+func createFile(path string, o Options) (Backend, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return openFile(path, o)
+}
+
+func (db *fileDB) Scan() error {
+	db.scanner = bufio.NewScanner(db.input())
+	db.scanner.Split(db.scanLines)
+	db.Next()
+	return nil
+}
+
+// Reset reads from the top again, compressed files are decompressed from the start
+func (db *fileDB) Reset() error {
+	var err error
+	if db.compression != "" {
+		err = db.openStream()
+	} else {
+		_, err = db.handle.Seek(0, 0)
+	}
+	if err != nil {
+		return err
+	}
+	db.offset, db.following = 0, false
+	return db.Scan()
+}
+
+// scanLines is bufio.ScanLines keeping track of the bytes read
+func (db *fileDB) scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && db.following && bytes.IndexByte(data, '\n') < 0 {
+		// the line is still being written
+		return 0, nil, nil
+	}
+	advance, token, err = bufio.ScanLines(data, atEOF)
+	db.offset += int64(advance)
+	return
+}
+
+// Follow reads on after the last complete line, last is not needed for that
+// Compressed files can't be followed
+func (db *fileDB) Follow(last []byte) bool {
+	if db.compression != "" {
+		return false
+	}
+	db.following = true
+	_, err := db.handle.Seek(db.offset, 0)
+	if err != nil {
+		return false
+	}
+	db.scanner = bufio.NewScanner(db.handle)
+	db.scanner.Split(db.scanLines)
+	return db.Next()
+}
+
+func (db *fileDB) Next() bool {
+	if db.scanner == nil || !db.scanner.Scan() {
+		db.key, db.value = nil, nil
+		return false
+	}
+	db.key, db.value = db.split(db.scanner.Text())
+	return true
+}
+
+// Seek moves to the first line with key >= k, counting from the top
+// This only makes sense for files sorted by key
+func (db *fileDB) Seek(k []byte) error {
+	err := db.Reset()
+	if err != nil {
+		return err
+	}
+	for db.key != nil && bytes.Compare(db.key, k) < 0 {
+		db.Next()
+	}
+	return nil
+}
+
+// split separates key and values of a line
+func (db *fileDB) split(line string) (key []byte, value []byte) {
+	row := strings.Split(line, " ")
+	if db.keyCol == -1 {
+		// try to detect location of key (if any) and values
+		for i := range row {
+			_, err := strconv.ParseFloat(row[i], 64)
+			if err != nil {
+				//not a float, assume it is the key
+				db.keyCol = i
+				break
+			}
+		}
+	}
+	if db.keyCol != -1 && db.keyCol < len(row) {
+		key = []byte(row[db.keyCol])
+		value = []byte(strings.Join(append(row[0:db.keyCol], row[db.keyCol+1:]...), " "))
+	} else {
+		value = []byte(strings.Join(row, " "))
+	}
+	return
+}
+
+func (db *fileDB) Key() []byte {
+	return db.key
+}
+
+func (db *fileDB) Value() []byte {
+	return db.value
+}
+
+// Put appends a new line with key and value, to a compressed file as a stream of its own
+func (db *fileDB) Put(key []byte, value []byte) error {
+	return db.appendLines([]BatchOp{{Key: key, Value: value}})
+}
+
+// WriteBatch appends the lines of consecutive puts together, to a compressed file as
+// one stream. Deletes rewrite the file in between
+func (db *fileDB) WriteBatch(ops []BatchOp) error {
+	for len(ops) > 0 {
+		if ops[0].Delete {
+			err := db.Delete(ops[0].Key)
+			if err != nil {
+				return err
+			}
+			ops = ops[1:]
+			continue
+		}
+		n := 1
+		for n < len(ops) && !ops[n].Delete {
+			n++
+		}
+		err := db.appendLines(ops[:n])
+		if err != nil {
+			return err
+		}
+		ops = ops[n:]
+	}
+	return nil
+}
+
+// appendLines appends a line for each of the puts in ops
+func (db *fileDB) appendLines(ops []BatchOp) error {
+	f, err := os.OpenFile(db.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	cw, err := Compressor(f, db.compression)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w := bufio.NewWriter(cw)
+	for _, op := range ops {
+		_, err = w.Write(db.formatLine(op.Key, op.Value))
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = cw.Close()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if db.lines != 0 {
+		db.lines += uint64(len(ops))
+	}
+	return f.Close()
+}
+
+// Delete rewrites the file without the lines with the given key
+func (db *fileDB) Delete(key []byte) error {
+	f, err := openText(db.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tmp, err := ioutil.TempFile(filepath.Dir(db.path), filepath.Base(db.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	info, err := db.handle.Stat()
+	if err == nil {
+		tmp.Chmod(info.Mode())
+	}
+	cw, err := Compressor(tmp, db.compression)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	var found bool
+	var lines uint64
+	scanner := bufio.NewScanner(f)
+	w := bufio.NewWriter(cw)
+	for scanner.Scan() {
+		k, _ := db.split(scanner.Text())
+		if bytes.Equal(k, key) {
+			found = true
+			continue
+		}
+		w.Write(scanner.Bytes())
+		w.WriteByte('\n')
+		lines++
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = cw.Close()
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("Not found")
+	}
+
+	err = os.Rename(tmp.Name(), db.path)
+	if err != nil {
+		return err
+	}
+	// reopen, the old handle still points to the replaced file
+	if db.stream != nil {
+		db.stream.Close()
+		db.stream = nil
+	}
+	db.handle.Close()
+	db.handle, err = os.Open(db.path)
+	if err != nil {
+		return err
+	}
+	err = db.openStream()
+	if err != nil {
+		return err
+	}
+	db.lines = lines
+	return db.Scan()
+}
+
+// formatLine puts key in its column among the space separated values
+func (db *fileDB) formatLine(key []byte, value []byte) []byte {
+	row := strings.Split(string(value), " ")
+	col := db.keyCol
+	if col < 0 || col > len(row) {
+		col = 0
+	}
+	row = append(row[:col], append([]string{string(key)}, row[col:]...)...)
+	return []byte(strings.Join(row, " ") + "\n")
+}
+
+// GetRandom jumps to a random byte offset and returns the line starting after it
+// It wraps around to the first line past the last one. Compressed files can't do that
+func (db *fileDB) GetRandom() (key []byte, value []byte, err error) {
+	if db.compression != "" {
+		return nil, nil, notSupported(db.compression+" file", "GetRandom")
+	}
+	info, err := db.handle.Stat()
+	if err != nil {
+		return
+	}
+	size := info.Size()
+	if size == 0 {
+		return nil, nil, errors.New("Empty file")
+	}
+	offset := rand.Int63n(size)
+	r := bufio.NewReader(io.NewSectionReader(db.handle, offset, size-offset))
+	if offset > 0 {
+		// sync to the start of the next line
+		_, err = r.ReadString('\n')
+		if err == io.EOF {
+			r = bufio.NewReader(io.NewSectionReader(db.handle, 0, size))
+		} else if err != nil {
+			return
+		}
+	}
+	line, err := r.ReadString('\n')
+	if err == io.EOF && line == "" {
+		r = bufio.NewReader(io.NewSectionReader(db.handle, 0, size))
+		line, err = r.ReadString('\n')
+	}
+	if err != nil && err != io.EOF {
+		return
+	}
+	key, value = db.split(strings.TrimRight(line, "\r\n"))
+	return key, value, nil
+}
+
+func (db *fileDB) Entries() uint64 {
+	if db.lines == 0 {
+		db.lines = getLineCount(db.path)
+	}
+	return db.lines
+}
+
+func (db *fileDB) Stat() ([]Property, error) {
+	keyCol := "none"
+	if db.keyCol >= 0 {
+		keyCol = strconv.Itoa(db.keyCol + 1)
+	}
+	compression := db.compression
+	if compression == "" {
+		compression = "none"
+	}
+	return []Property{
+		{"lines", strconv.FormatUint(db.Entries(), 10)},
+		{"key column", keyCol},
+		{"compression", compression},
+	}, nil
+}
+
+func (db *fileDB) Release() {
+	db.scanner = nil
+}
+
+func (db *fileDB) Close() error {
+	if db.stream != nil {
+		db.stream.Close()
+	}
+	return db.handle.Close()
+}
+
+// NewCursor opens the file again, the new handle reads independently
+func (db *fileDB) NewCursor() (Backend, error) {
+	b, err := openFile(db.path, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := b.(*fileDB)
+	c.keyCol, c.lines = db.keyCol, db.lines
+	return c, nil
+}
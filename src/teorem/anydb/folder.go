@@ -0,0 +1,219 @@
+package anydb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	Register("folder", openFolder, createFolder)
+}
+
+// folderDB is a directory where every file is a record, typically images
+// Files are sorted by name, so the folder can be iterated like any other key-value db
+type folderDB struct {
+	path     string
+	files    []string
+	iterator int
+	value    []byte
+}
+
+func openFolder(path string, o Options) (Backend, error) {
+	// this can take a LONG time when opening large directories
+	fmt.Printf("Scanning folder...")
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	fmt.Printf("Done\n")
+	return &folderDB{path: path, files: files}, nil
+}
+
+// createFolder opens the folder at path, creating it if missing
+func createFolder(path string, o Options) (Backend, error) {
+	err := os.MkdirAll(path, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return openFolder(path, o)
+}
+
+func (db *folderDB) Scan() error {
+	return nil
+}
+
+func (db *folderDB) Reset() error {
+	db.iterator = 0
+	db.value = nil
+	return nil
+}
+
+// Seek moves to the first file with name >= k
+func (db *folderDB) Seek(k []byte) error {
+	db.value = nil
+	db.iterator = sort.SearchStrings(db.files, string(k))
+	return nil
+}
+
+func (db *folderDB) Next() bool {
+	db.value = nil
+	if db.iterator < len(db.files)-1 {
+		db.iterator++
+		return true
+	}
+	return false
+}
+
+func (db *folderDB) Last() error {
+	db.value = nil
+	db.iterator = len(db.files) - 1
+	if db.iterator < 0 {
+		db.iterator = 0
+	}
+	return nil
+}
+
+func (db *folderDB) Prev() bool {
+	db.value = nil
+	if db.iterator > 0 {
+		db.iterator--
+		return true
+	}
+	return false
+}
+
+func (db *folderDB) Key() []byte {
+	if db.iterator >= len(db.files) {
+		return nil
+	}
+	return []byte(db.files[db.iterator])
+}
+
+func (db *folderDB) Value() []byte {
+	if db.value == nil && db.iterator < len(db.files) {
+		db.value, _ = ioutil.ReadFile(filepath.Join(db.path, db.files[db.iterator]))
+	}
+	return db.value
+}
+
+func (db *folderDB) Get(key []byte) ([]byte, error) {
+	i := sort.SearchStrings(db.files, string(key))
+	if i < len(db.files) && db.files[i] == string(key) {
+		return ioutil.ReadFile(filepath.Join(db.path, db.files[i]))
+	}
+	return nil, errors.New("No such file")
+}
+
+// Put writes value to a file named by key
+func (db *folderDB) Put(key []byte, value []byte) error {
+	name, err := folderFileName(key)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(db.path, name), value, 0644)
+	if err != nil {
+		return err
+	}
+	// keep the list sorted
+	i := sort.SearchStrings(db.files, name)
+	if i < len(db.files) && db.files[i] == name {
+		if i == db.iterator {
+			db.value = nil
+		}
+		return nil
+	}
+	db.files = append(db.files, "")
+	copy(db.files[i+1:], db.files[i:])
+	db.files[i] = name
+	if i <= db.iterator && len(db.files) > 1 {
+		db.iterator++
+	}
+	return nil
+}
+
+// Delete removes the file named by key
+func (db *folderDB) Delete(key []byte) error {
+	name, err := folderFileName(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(db.path, name))
+	if err != nil {
+		return err
+	}
+	i := sort.SearchStrings(db.files, name)
+	if i < len(db.files) && db.files[i] == name {
+		db.files = append(db.files[:i], db.files[i+1:]...)
+		if db.iterator > i {
+			db.iterator--
+		}
+		db.value = nil
+	}
+	return nil
+}
+
+// folderFileName checks that key can be used as a file name inside the folder
+func folderFileName(key []byte) (string, error) {
+	name := string(key)
+	if name == "" || name == "." || name == ".." || strings.ContainsRune(name, os.PathSeparator) {
+		return "", fmt.Errorf("Key can't be used as file name: %q", name)
+	}
+	return name, nil
+}
+
+// GetRandom returns a random file from the folder
+func (db *folderDB) GetRandom() (key []byte, value []byte, err error) {
+	if len(db.files) == 0 {
+		return nil, nil, errors.New("Empty folder")
+	}
+	i := rand.Intn(len(db.files))
+	key = []byte(db.files[i])
+	value, err = ioutil.ReadFile(filepath.Join(db.path, db.files[i]))
+	return
+}
+
+// Image tries to load the current file as an image
+func (db *folderDB) Image() (image.Image, error) {
+	return imaging.Decode(bytes.NewReader(db.Value()))
+}
+
+func (db *folderDB) Entries() uint64 {
+	return uint64(len(db.files))
+}
+
+func (db *folderDB) Stat() ([]Property, error) {
+	p := []Property{{"files", fmt.Sprint(len(db.files))}}
+	if len(db.files) > 0 {
+		p = append(p, Property{"first", db.files[0]}, Property{"last", db.files[len(db.files)-1]})
+	}
+	return p, nil
+}
+
+func (db *folderDB) Release() {
+}
+
+func (db *folderDB) Close() error {
+	return nil
+}
+
+// NewCursor returns an iterator over the files listed so far, Put and Delete of one
+// don't change the list of the other
+func (db *folderDB) NewCursor() (Backend, error) {
+	return &folderDB{path: db.path, files: append([]string(nil), db.files...)}, nil
+}
@@ -0,0 +1,345 @@
+package anydb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+func init() {
+	Register("imagefolder", openImageFolder, nil)
+}
+
+// imageFolderDB is an ImageNet style tree root/<class>/<file>, files can be nested deeper
+// Keys are paths relative to root with forward slashes and the label of a record is the
+// index of its class in the sorted list of classes
+// Class folders are only listed when the iterator gets there
+type imageFolderDB struct {
+	path    string
+	classes []string
+	// files of each class relative to the class folder, nil until listed
+	files [][]string
+
+	class    int
+	iterator int
+	value    []byte
+}
+
+func openImageFolder(path string, o Options) (Backend, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	var classes []string
+	for _, info := range infos {
+		if info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			// sort as "class/" so the order of classes is the order of keys
+			classes = append(classes, info.Name()+"/")
+		}
+	}
+	if len(classes) == 0 {
+		return nil, errors.New("No class folders found")
+	}
+	sort.Strings(classes)
+	for i := range classes {
+		classes[i] = strings.TrimSuffix(classes[i], "/")
+	}
+	return &imageFolderDB{path: path, classes: classes, files: make([][]string, len(classes))}, nil
+}
+
+// isImageFolder returns true if the first entries of the folder at path are all folders
+func isImageFolder(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	infos, err := f.Readdir(100)
+	if err != nil || len(infos) == 0 {
+		return false
+	}
+	for _, info := range infos {
+		if !info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// list walks the folder of class c if it hasn't been done yet
+func (db *imageFolderDB) list(c int) []string {
+	if db.files[c] != nil {
+		return db.files[c]
+	}
+	root := filepath.Join(db.path, db.classes[c])
+	files := []string{}
+	filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") && p != root {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(root, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	db.files[c] = files
+	return files
+}
+
+// listAll walks all class folders, returns the number of files
+func (db *imageFolderDB) listAll() (n int) {
+	for c := range db.classes {
+		n += len(db.list(c))
+	}
+	return
+}
+
+// split returns the class index and the file of a key, class is -1 for unknown classes
+func (db *imageFolderDB) split(key []byte) (class int, file string) {
+	k := string(key)
+	i := strings.Index(k, "/")
+	if i < 0 {
+		return -1, ""
+	}
+	class = db.classIndex(k[:i])
+	return class, k[i+1:]
+}
+
+func (db *imageFolderDB) classIndex(name string) int {
+	i := sort.Search(len(db.classes), func(i int) bool { return db.classes[i]+"/" >= name+"/" })
+	if i < len(db.classes) && db.classes[i] == name {
+		return i
+	}
+	return -1
+}
+
+func (db *imageFolderDB) Scan() error {
+	return nil
+}
+
+func (db *imageFolderDB) Reset() error {
+	db.class, db.iterator = 0, 0
+	db.value = nil
+	db.skipForward()
+	return nil
+}
+
+// skipForward moves past the end of empty classes, or the end of the current one
+func (db *imageFolderDB) skipForward() bool {
+	for db.class < len(db.classes) && db.iterator >= len(db.list(db.class)) {
+		db.class++
+		db.iterator = 0
+	}
+	return db.class < len(db.classes)
+}
+
+// skipBackward moves before the start of the current class to the end of the previous one
+func (db *imageFolderDB) skipBackward() bool {
+	for db.class >= 0 && db.iterator < 0 {
+		db.class--
+		if db.class >= 0 {
+			db.iterator = len(db.list(db.class)) - 1
+		}
+	}
+	if db.class < 0 {
+		db.class, db.iterator = 0, -1
+		return false
+	}
+	return true
+}
+
+// Seek moves to the first key >= k
+func (db *imageFolderDB) Seek(k []byte) error {
+	db.value = nil
+	s := string(k)
+	class := s
+	file := ""
+	if i := strings.Index(s, "/"); i >= 0 {
+		class, file = s[:i], s[i+1:]
+	}
+	db.class = sort.Search(len(db.classes), func(i int) bool { return db.classes[i]+"/" >= class+"/" })
+	db.iterator = 0
+	if db.class < len(db.classes) && db.classes[db.class] == class {
+		db.iterator = sort.SearchStrings(db.list(db.class), file)
+	}
+	db.skipForward()
+	return nil
+}
+
+func (db *imageFolderDB) Next() bool {
+	db.value = nil
+	if db.class >= len(db.classes) {
+		return false
+	}
+	db.iterator++
+	if !db.skipForward() {
+		// stay on the last record, like the other backends
+		db.Last()
+		return false
+	}
+	return true
+}
+
+func (db *imageFolderDB) Last() error {
+	db.value = nil
+	db.class = len(db.classes) - 1
+	db.iterator = len(db.list(db.class)) - 1
+	db.skipBackward()
+	return nil
+}
+
+func (db *imageFolderDB) Prev() bool {
+	db.value = nil
+	db.iterator--
+	return db.skipBackward()
+}
+
+// valid is true if the iterator is on a record
+func (db *imageFolderDB) valid() bool {
+	return db.class >= 0 && db.class < len(db.classes) && db.iterator >= 0 && db.iterator < len(db.list(db.class))
+}
+
+func (db *imageFolderDB) Key() []byte {
+	if !db.valid() {
+		return nil
+	}
+	return []byte(db.classes[db.class] + "/" + db.files[db.class][db.iterator])
+}
+
+func (db *imageFolderDB) Value() []byte {
+	if db.value == nil && db.valid() {
+		db.value, _ = ioutil.ReadFile(db.file(db.class, db.files[db.class][db.iterator]))
+	}
+	return db.value
+}
+
+func (db *imageFolderDB) file(class int, file string) string {
+	return filepath.Join(db.path, db.classes[class], filepath.FromSlash(file))
+}
+
+func (db *imageFolderDB) Get(key []byte) ([]byte, error) {
+	class, file := db.split(key)
+	if class < 0 {
+		return nil, errors.New("No such class")
+	}
+	files := db.list(class)
+	i := sort.SearchStrings(files, file)
+	if i == len(files) || files[i] != file {
+		return nil, errors.New("No such file")
+	}
+	return ioutil.ReadFile(db.file(class, file))
+}
+
+// GetRandom lists all classes the first time, to pick uniformly over all files
+func (db *imageFolderDB) GetRandom() (key []byte, value []byte, err error) {
+	n := db.listAll()
+	if n == 0 {
+		return nil, nil, errors.New("Empty folder")
+	}
+	r := rand.Intn(n)
+	for c := range db.classes {
+		if r < len(db.files[c]) {
+			key = []byte(db.classes[c] + "/" + db.files[c][r])
+			value, err = ioutil.ReadFile(db.file(c, db.files[c][r]))
+			return
+		}
+		r -= len(db.files[c])
+	}
+	return
+}
+
+// Classes returns the class folders, the label of a record is the index of its class
+func (db *imageFolderDB) Classes() []string {
+	return db.classes
+}
+
+// LabelOf returns the index of the class of key
+func (db *imageFolderDB) LabelOf(key []byte) (int32, error) {
+	class, _ := db.split(key)
+	if class < 0 {
+		return 0, fmt.Errorf("No class for %s", key)
+	}
+	return int32(class), nil
+}
+
+// Image tries to load the current file as an image
+func (db *imageFolderDB) Image() (image.Image, error) {
+	return imaging.Decode(bytes.NewReader(db.Value()))
+}
+
+// Entries is only known once every class folder has been listed
+func (db *imageFolderDB) Entries() uint64 {
+	var n uint64
+	for _, files := range db.files {
+		if files == nil {
+			return 0
+		}
+		n += uint64(len(files))
+	}
+	return n
+}
+
+// EstimateEntries extrapolates from the classes listed so far, listing the first if none
+func (db *imageFolderDB) EstimateEntries() (uint64, error) {
+	var n, listed int
+	for _, files := range db.files {
+		if files != nil {
+			n += len(files)
+			listed++
+		}
+	}
+	if listed == 0 {
+		n, listed = len(db.list(0)), 1
+	}
+	return uint64(n * len(db.classes) / listed), nil
+}
+
+func (db *imageFolderDB) Stat() ([]Property, error) {
+	var listed int
+	for _, files := range db.files {
+		if files != nil {
+			listed++
+		}
+	}
+	return []Property{
+		{"classes", fmt.Sprint(len(db.classes))},
+		{"classes listed", fmt.Sprint(listed)},
+		{"first class", db.classes[0]},
+		{"last class", db.classes[len(db.classes)-1]},
+	}, nil
+}
+
+func (db *imageFolderDB) Release() {
+}
+
+func (db *imageFolderDB) Close() error {
+	return nil
+}
+
+// NewCursor returns an iterator of its own, it lists the class folders not listed yet
+// by itself so cursors can be used from different goroutines
+func (db *imageFolderDB) NewCursor() (Backend, error) {
+	return &imageFolderDB{path: db.path, classes: db.classes, files: append([][]string(nil), db.files...)}, nil
+}
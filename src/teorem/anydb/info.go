@@ -0,0 +1,167 @@
+package anydb
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Estimator is implemented by backends that don't track the number of records
+// but can estimate it
+type Estimator interface {
+	EstimateEntries() (uint64, error)
+}
+
+// Property is a backend specific statistic, like the page size or compaction state
+type Property struct {
+	Name  string
+	Value string
+}
+
+// SizeStats are the lengths of keys or values found in a number of samples
+type SizeStats struct {
+	Samples int
+	Min     int
+	Max     int
+	Mean    float64
+}
+
+// add counts one more sample of length n
+func (s *SizeStats) add(n int) {
+	if s.Samples == 0 || n < s.Min {
+		s.Min = n
+	}
+	if n > s.Max {
+		s.Max = n
+	}
+	s.Mean += (float64(n) - s.Mean) / float64(s.Samples+1)
+	s.Samples++
+}
+
+// Info describes the contents and storage of a db
+type Info struct {
+	Identity string
+	Path     string
+
+	// Entries is the number of records, Estimated is set if it isn't exact
+	// and Entries is 0 when the backend can't tell at all
+	Entries   uint64
+	Estimated bool
+
+	// DiskSize is the number of bytes used by the files of the db, -1 if unknown
+	DiskSize int64
+
+	// Keys and Values are sampled with GetRandom, no samples if it isn't supported
+	// or fails
+	Keys   SizeStats
+	Values SizeStats
+
+	// Properties are the backend statistics returned by Stat
+	Properties []Property
+}
+
+// EstimateEntries returns the number of records, and true if it is only an estimate
+// Returns 0 if the backend can't count nor estimate them
+func (db *ADB) EstimateEntries() (entries uint64, estimated bool) {
+	entries = db.backend.Entries()
+	if entries != 0 {
+		return entries, false
+	}
+	e, ok := db.backend.(Estimator)
+	if !ok {
+		return 0, false
+	}
+	entries, err := e.EstimateEntries()
+	if err != nil {
+		return 0, false
+	}
+	return entries, true
+}
+
+// Info gathers everything known about the db, key and value sizes are
+// taken from the given number of random records
+func (db *ADB) Info(samples int) (*Info, error) {
+	info := &Info{Identity: db.identity, Path: db.path}
+	info.Entries, info.Estimated = db.EstimateEntries()
+	info.DiskSize = diskSize(db.path)
+
+	if info.Entries != 0 {
+		if err := db.sampleSizes(info, samples); err != nil {
+			// the other stats are still worth showing
+			info.Keys, info.Values = SizeStats{}, SizeStats{}
+		}
+	}
+
+	var err error
+	info.Properties, err = db.Stat()
+	if IsNotSupported(err) {
+		err = nil
+	}
+	return info, err
+}
+
+// sampleSizes adds the key and value sizes of records found by seeking a cursor to
+// random keys between the first and the last one. They aren't picked uniformly, but
+// sizes don't depend much on where keys fall and no keys have to be walked. Backends
+// that can't seek and iterate backwards give GetRandom records instead
+func (db *ADB) sampleSizes(info *Info, samples int) error {
+	_, seeker := db.backend.(Seeker)
+	_, reverser := db.backend.(Reverser)
+	_, cursorer := db.backend.(Cursorer)
+	if !seeker || !reverser || !cursorer {
+		if _, ok := db.backend.(RandomGetter); !ok {
+			return nil
+		}
+		for i := 0; i < samples; i++ {
+			key, value, err := db.GetRandom()
+			if err != nil {
+				return err
+			}
+			info.Keys.add(len(key))
+			info.Values.add(len(value))
+		}
+		return nil
+	}
+
+	c, err := db.NewCursor()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	c.reverse = false
+	if err = c.Reset(); err != nil || !c.Valid() {
+		return err
+	}
+	first := append([]byte(nil), c.backend.Key()...)
+	if err = c.Last(); err != nil {
+		return err
+	}
+	last := append([]byte(nil), c.backend.Key()...)
+	for i := 0; i < samples; i++ {
+		if err = c.Seek(randomKey(first, last)); err != nil {
+			return err
+		}
+		if c.Valid() {
+			info.Keys.add(len(c.backend.Key()))
+			info.Values.add(len(c.Value()))
+		}
+	}
+	return nil
+}
+
+// diskSize returns the size of a file, or of all files in a directory
+func diskSize(path string) (size int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return -1
+	}
+	if !info.IsDir() {
+		return info.Size()
+	}
+	filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
@@ -0,0 +1,70 @@
+package anydb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	const records = 10000
+	for _, dbType := range []string{"leveldb", "bolt"} {
+		path := filepath.Join(dir, dbType)
+		db, err := Create(path, dbType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := db.NewWriter(records, 0)
+		for i := 0; i < records; i++ {
+			value := make([]byte, 200)
+			rand.Read(value)
+			if err = w.Put([]byte(fmt.Sprintf("%08d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+		// reopening moves the leveldb records from the journal to a table
+		db, err = Open(path, dbType)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := db.Info(100)
+		if err != nil {
+			t.Fatalf("%v: %v", dbType, err)
+		}
+		// leveldb estimates from the table size, which holds the keys and some index
+		if info.Entries < records*9/10 || info.Entries > records*11/10 {
+			t.Errorf("%v: %v entries, want about %v", dbType, info.Entries, records)
+		}
+		if info.Keys.Samples != 100 || info.Keys.Min != 8 || info.Keys.Max != 8 {
+			t.Errorf("%v: key sizes %+v", dbType, info.Keys)
+		}
+		if info.Values.Min != 200 || info.Values.Max != 200 {
+			t.Errorf("%v: value sizes %+v", dbType, info.Values)
+		}
+
+		// a write changes the count of bolt
+		if err = db.Put([]byte("x"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if dbType == "bolt" {
+			if n, _ := db.EstimateEntries(); n != records+1 {
+				t.Errorf("bolt: %v entries after a put, want %v", n, records+1)
+			}
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,244 @@
+package anydb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+var iterationKeys = []string{"a1", "a2", "b1", "b2", "b3", "c1"}
+
+// iterationDBs creates a folder and a file db in dir holding iterationKeys
+func iterationDBs(t *testing.T, dir string) []*ADB {
+	var dbs []*ADB
+	for _, c := range []struct{ name, dbType string }{{"folder", "folder"}, {"lines.txt", "file"}} {
+		db, err := Create(filepath.Join(dir, c.name), c.dbType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range iterationKeys {
+			if err = db.Put([]byte(k), []byte("1")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.Scan()
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+// iterate returns the keys from the reset iterator to the end
+func iterate(db *ADB) (keys []string) {
+	db.Reset()
+	for db.Valid() {
+		keys = append(keys, string(db.Key()))
+		if !db.Next() {
+			break
+		}
+	}
+	return
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []byte
+	}{
+		{"a", []byte("b")},
+		{"ab", []byte("ac")},
+		{"a\xff", []byte("b")},
+		{"a\xff\xff", []byte("b")},
+		{"\xff", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		if end := prefixEnd([]byte(test.prefix)); !reflect.DeepEqual(end, test.want) {
+			t.Errorf("%q: got %q, want %q", test.prefix, end, test.want)
+		}
+	}
+}
+
+func TestKeyRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		// start and end, "" for no bound, or a prefix
+		start  string
+		end    string
+		prefix string
+		want   []string
+	}{
+		{"", "", "", iterationKeys},
+		{"b", "", "", []string{"b1", "b2", "b3", "c1"}},
+		{"", "b", "", []string{"a1", "a2"}},
+		{"a2", "b2", "", []string{"a2", "b1"}},
+		{"b4", "c", "", nil},
+		{"", "", "b", []string{"b1", "b2", "b3"}},
+		{"", "", "c1", []string{"c1"}},
+		{"", "", "d", nil},
+	}
+	for _, db := range iterationDBs(t, dir) {
+		for _, test := range tests {
+			if test.prefix != "" {
+				err = db.SetPrefix([]byte(test.prefix))
+			} else {
+				var start, end []byte
+				if test.start != "" {
+					start = []byte(test.start)
+				}
+				if test.end != "" {
+					end = []byte(test.end)
+				}
+				err = db.SetRange(start, end)
+			}
+			if err != nil {
+				t.Fatalf("%v: %v", db.Identity(), err)
+			}
+			if keys := iterate(db); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("%v %+v: got %v", db.Identity(), test, keys)
+			}
+		}
+		db.Close()
+	}
+}
+
+func TestReverseIteration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		start string
+		end   string
+		want  []string
+	}{
+		{"", "", []string{"c1", "b3", "b2", "b1", "a2", "a1"}},
+		{"b", "", []string{"c1", "b3", "b2", "b1"}},
+		{"", "b", []string{"a2", "a1"}},
+		{"a2", "b2", []string{"b1", "a2"}},
+		{"b4", "c", nil},
+	}
+	dbs := iterationDBs(t, dir)
+	for _, db := range dbs {
+		defer db.Close()
+	}
+	folder, file := dbs[0], dbs[1]
+
+	if err = file.SetReverse(true); !IsNotSupported(err) {
+		t.Errorf("file: SetReverse gave %v", err)
+	}
+	if err = folder.SetReverse(true); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		var start, end []byte
+		if test.start != "" {
+			start = []byte(test.start)
+		}
+		if test.end != "" {
+			end = []byte(test.end)
+		}
+		if err = folder.SetRange(start, end); err != nil {
+			t.Fatal(err)
+		}
+		if keys := iterate(folder); !reflect.DeepEqual(keys, test.want) {
+			t.Errorf("%+v: got %v", test, keys)
+		}
+	}
+
+	// Prev past the end of the range goes back to the last record
+	folder.SetReverse(false)
+	folder.SetRange([]byte("a"), []byte("b"))
+	iterate(folder)
+	if !folder.Prev() || string(folder.Key()) != "a2" {
+		t.Errorf("Prev past the end: %q", folder.Key())
+	}
+	if !folder.Prev() || string(folder.Key()) != "a1" || folder.Prev() {
+		t.Errorf("Prev to the start: %q", folder.Key())
+	}
+}
+
+func TestKeyFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		filter  string
+		prefix  string
+		reverse bool
+		want    []string
+	}{
+		{"[13]$", "", false, []string{"a1", "b1", "b3", "c1"}},
+		{"^b", "", false, []string{"b1", "b2", "b3"}},
+		{"[13]$", "b", false, []string{"b1", "b3"}},
+		{"x", "", false, nil},
+		{"[13]$", "", true, []string{"c1", "b3", "b1", "a1"}},
+		{"1", "a", true, []string{"a1"}},
+		{"", "", false, iterationKeys},
+	}
+	for _, db := range iterationDBs(t, dir) {
+		for _, test := range tests {
+			if test.reverse && db.Identity() == "file" {
+				continue
+			}
+			var re *regexp.Regexp
+			if test.filter != "" {
+				re = regexp.MustCompile(test.filter)
+			}
+			if err = db.SetReverse(test.reverse); err != nil {
+				t.Fatal(err)
+			}
+			if err = db.SetPrefix([]byte(test.prefix)); err != nil {
+				t.Fatal(err)
+			}
+			if err = db.SetKeyFilter(re); err != nil {
+				t.Fatal(err)
+			}
+			if keys := iterate(db); !reflect.DeepEqual(keys, test.want) {
+				t.Errorf("%v %+v: got %v", db.Identity(), test, keys)
+			}
+		}
+		db.Close()
+	}
+}
+
+func TestMapKey(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		replacement string
+		i, j        int
+		key         string
+		want        string
+	}{
+		{"", "", 0, 0, "train/00001.jpg", "train/00001.jpg"},
+		{`^(\w+)/(\d+)\.jpg$`, "${2}_$1", 0, 0, "train/00001.jpg", "00001_train"},
+		{"x", "y", 0, 0, "abc", "abc"},
+		// filter ranges include j
+		{"", "", 2, 4, "abcdef", "cde"},
+		{"", "", 2, 0, "abcdef", "cdef"},
+		{"", "", 0, 10, "abcdef", "abcdef"},
+		{"", "", 8, 0, "abcdef", ""},
+		{"", "", 4, 2, "abcdef", ""},
+		// the key map goes first
+		{"^id_", "", 0, 1, "id_42", "42"},
+	}
+	for _, test := range tests {
+		db := &ADB{}
+		if test.pattern != "" {
+			db.SetKeyMap(regexp.MustCompile(test.pattern), test.replacement)
+		}
+		db.SetFilterRange(test.i, test.j)
+		if key := db.MapKey([]byte(test.key)); string(key) != test.want {
+			t.Errorf("%+v: got %q", test, key)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package anydb
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func init() {
+	Register("leveldb", openLevelDB, createLevelDB)
+}
+
+// leveldbEstimateSamples is the number of records read to estimate the size of a record
+const leveldbEstimateSamples = 100
+
+type levelDB struct {
+	db       *leveldb.DB
+	iterator iterator.Iterator
+	// db belongs to the levelDB this cursor was made from
+	shared bool
+}
+
+func openLevelDB(path string, o Options) (Backend, error) {
+	var options opt.Options
+	options.ErrorIfMissing = true
+	ldb, err := leveldb.OpenFile(path, &options)
+	if err != nil {
+		return nil, err
+	}
+	return &levelDB{db: ldb}, nil
+}
+
+// createLevelDB opens the db at path, creating it if missing
+func createLevelDB(path string, o Options) (Backend, error) {
+	ldb, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &levelDB{db: ldb}, nil
+}
+
+func (db *levelDB) Scan() error {
+	if db.iterator != nil {
+		return nil
+	}
+	db.iterator = db.db.NewIterator(nil, nil)
+	return db.Reset()
+}
+
+func (db *levelDB) Reset() error {
+	if db.iterator == nil {
+		return db.Scan()
+	}
+	db.iterator.First()
+	return db.iterator.Error()
+}
+
+func (db *levelDB) Seek(k []byte) error {
+	if db.iterator == nil {
+		err := db.Scan()
+		if err != nil {
+			return err
+		}
+	}
+	db.iterator.Seek(k)
+	return db.iterator.Error()
+}
+
+func (db *levelDB) Next() bool {
+	return db.iterator.Next()
+}
+
+func (db *levelDB) Last() error {
+	if db.iterator == nil {
+		err := db.Scan()
+		if err != nil {
+			return err
+		}
+	}
+	db.iterator.Last()
+	return db.iterator.Error()
+}
+
+func (db *levelDB) Prev() bool {
+	return db.iterator.Prev()
+}
+
+func (db *levelDB) Key() []byte {
+	return db.iterator.Key()
+}
+
+func (db *levelDB) Value() []byte {
+	return db.iterator.Value()
+}
+
+// GetRandom picks a byte offset of the tables uniformly, finds the block holding it
+// by halving the key range with SizeOf, and picks one of the records of that block.
+// Records still in the journal aren't in any table, they are only picked when all are.
+// The last block of a table also gets the size of the table index, a small bias
+func (db *levelDB) GetRandom() (key []byte, value []byte, err error) {
+	it := db.db.NewIterator(nil, nil)
+	defer it.Release()
+	if !it.Last() {
+		if err = it.Error(); err == nil {
+			err = errors.New("Empty db")
+		}
+		return
+	}
+	// hi is the smallest key after the last one
+	hi := append(append([]byte{}, it.Key()...), 0)
+	it.First()
+	first := append([]byte{}, it.Key()...)
+	total, err := db.SizeOf(first, hi)
+	if err != nil {
+		return
+	}
+	n := 0
+	pick := func() {
+		n++
+		if rand.Intn(n) == 0 {
+			key = append(key[:0], it.Key()...)
+			value = append(value[:0], it.Value()...)
+		}
+	}
+	if total == 0 {
+		for ok := true; ok; ok = it.Next() {
+			pick()
+		}
+		return key, value, it.Error()
+	}
+	offset := rand.Int63n(total)
+	lo := first
+	for {
+		splits := splitKeys(lo, hi, 2)
+		if len(splits) == 0 {
+			break
+		}
+		size, err := db.SizeOf(first, splits[0])
+		if err != nil {
+			return nil, nil, err
+		}
+		if size > offset {
+			hi = splits[0]
+		} else {
+			lo = splits[0]
+		}
+	}
+	// the block holds the last record before hi and the ones before it at no distance
+	if it.Seek(hi) {
+		it.Prev()
+	} else {
+		it.Last()
+	}
+	end := append([]byte{}, it.Key()...)
+	for ok := true; ok; ok = it.Prev() {
+		size, err := db.SizeOf(it.Key(), end)
+		if err != nil {
+			return nil, nil, err
+		}
+		if size > 0 {
+			break
+		}
+		pick()
+	}
+	return key, value, it.Error()
+}
+
+func (db *levelDB) Get(key []byte) ([]byte, error) {
+	return db.db.Get(key, nil)
+}
+
+func (db *levelDB) Put(key []byte, value []byte) error {
+	return db.db.Put(key, value, nil)
+}
+
+func (db *levelDB) Delete(key []byte) error {
+	return db.db.Delete(key, nil)
+}
+
+// WriteBatch applies all ops atomically with a leveldb.Batch
+func (db *levelDB) WriteBatch(ops []BatchOp) error {
+	batch := new(leveldb.Batch)
+	for _, op := range ops {
+		if op.Delete {
+			batch.Delete(op.Key)
+		} else {
+			batch.Put(op.Key, op.Value)
+		}
+	}
+	return db.db.Write(batch, nil)
+}
+
+// Entries isn't tracked by leveldb
+func (db *levelDB) Entries() uint64 {
+	return 0
+}
+
+// EstimateEntries divides the approximate size of the whole db by the mean size
+// of the records found by seeking to some random keys. Compression makes it an
+// underestimate
+func (db *levelDB) EstimateEntries() (uint64, error) {
+	size, err := db.SizeOf(nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	it := db.db.NewIterator(nil, nil)
+	defer it.Release()
+	if size == 0 {
+		// everything is still in the journal, count the records instead
+		var n uint64
+		for it.Next() {
+			n++
+		}
+		return n, it.Error()
+	}
+	if !it.Last() {
+		return 0, it.Error()
+	}
+	last := append([]byte{}, it.Key()...)
+	it.First()
+	first := append([]byte{}, it.Key()...)
+	var mean float64
+	for i := 0; i < leveldbEstimateSamples; i++ {
+		if !it.Seek(randomKey(first, last)) {
+			it.Last()
+		}
+		mean += float64(len(it.Key())+len(it.Value())) / leveldbEstimateSamples
+	}
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+	return uint64(float64(size)/mean + 0.5), nil
+}
+
+// Stat returns the number of tables and compaction work done on each level
+func (db *levelDB) Stat() ([]Property, error) {
+	stats, err := db.db.GetProperty("leveldb.stats")
+	if err != nil {
+		return nil, err
+	}
+	var p []Property
+	// rows of the compaction table look like
+	//    0   |          1 |       0.00032 |       0.00000 |       0.00000 |       0.00000
+	for _, line := range strings.Split(stats, "\n") {
+		f := strings.Split(line, "|")
+		if len(f) != 6 {
+			continue
+		}
+		for i := range f {
+			f[i] = strings.TrimSpace(f[i])
+		}
+		if _, err := strconv.Atoi(f[0]); err != nil {
+			continue
+		}
+		p = append(p, Property{"level " + f[0], fmt.Sprintf("%v tables, %v Mb, compaction %vs read %v Mb write %v Mb", f[1], f[2], f[3], f[4], f[5])})
+	}
+	for _, name := range []string{"openedtables", "aliveiters", "alivesnaps"} {
+		v, err := db.db.GetProperty("leveldb." + name)
+		if err == nil {
+			p = append(p, Property{name, v})
+		}
+	}
+	return p, nil
+}
+
+func (db *levelDB) SizeOf(start []byte, stop []byte) (int64, error) {
+	sizes, err := db.db.SizeOf([]util.Range{{Start: start, Limit: stop}})
+	if err != nil {
+		return 0, err
+	}
+	return sizes[0], nil
+}
+
+func (db *levelDB) Release() {
+	if db.iterator != nil {
+		db.iterator.Release()
+		db.iterator = nil
+	}
+}
+
+func (db *levelDB) Close() error {
+	db.Release()
+	if db.shared {
+		return nil
+	}
+	return db.db.Close()
+}
+
+// NewCursor returns an iterator of its own on the same db
+func (db *levelDB) NewCursor() (Backend, error) {
+	return &levelDB{db: db.db, shared: true}, nil
+}
@@ -0,0 +1,492 @@
+package anydb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"syscall"
+
+	"github.com/bmatsuo/lmdb-go/lmdb"
+)
+
+func init() {
+	Register("lmdb", openLMDB, createLMDB)
+}
+
+// lmdbMaxDBs is the number of named sub-databases that can be used in one env
+const lmdbMaxDBs = 128
+
+// lmdbCreateMapSize is the map size of new envs, the file only grows as it is filled
+const lmdbCreateMapSize = 1 << 40
+
+type lmdbDB struct {
+	dbi    lmdb.DBI
+	env    *lmdb.Env
+	txn    *lmdb.Txn
+	cursor *lmdb.Cursor
+	key    []byte
+	value  []byte
+
+	// selected sub-database, "" for the root one
+	name     string
+	readOnly bool
+	noLock   bool
+	// double the map size when it is full
+	grow bool
+	// the env belongs to the lmdbDB this cursor was made from
+	shared bool
+}
+
+// openLMDB opens an env with locking like caffe does. Options: readonly is safe while
+// caffe reads or writes the same env, nolock is only for envs no other process uses,
+// mapsize=<n> sets the map size (k, m, g or t suffix), nogrow fails writes with
+// MDB_MAP_FULL instead of doubling the map and db=<name> selects a sub-database
+func openLMDB(path string, o Options) (Backend, error) {
+	return openLMDBEnv(path, o, false)
+}
+
+func createLMDB(path string, o Options) (Backend, error) {
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return openLMDBEnv(path, o, true)
+}
+
+func openLMDBEnv(path string, o Options, create bool) (Backend, error) {
+	db := &lmdbDB{
+		readOnly: o.Bool("readonly"),
+		noLock:   o.Bool("nolock"),
+		grow:     !o.Bool("nogrow"),
+	}
+	if create && db.readOnly {
+		return nil, errors.New("Can't create a read-only lmdb")
+	}
+	var mapSize int64
+	if create {
+		mapSize = lmdbCreateMapSize
+	}
+	mapSize, err := o.Size("mapsize", mapSize)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.openEnv(path, mapSize)
+	if db.readOnly && !db.noLock && (lmdb.IsErrnoSys(err, syscall.EACCES) || lmdb.IsErrnoSys(err, syscall.EROFS)) {
+		// the lock file can't be written, caffe also falls back to no locking then
+		db.noLock = true
+		err = db.openEnv(path, mapSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = db.openDBI(o.Get("db", ""), create)
+	if err != nil {
+		db.env.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// openEnv sets up db.env, a failed env can't be opened again so it is closed
+func (db *lmdbDB) openEnv(path string, mapSize int64) error {
+	env, err := lmdb.NewEnv()
+	if err != nil {
+		return err
+	}
+	env.SetMaxDBs(lmdbMaxDBs)
+	if mapSize > 0 {
+		env.SetMapSize(mapSize)
+	}
+	// iterators keep a read transaction open across goroutines
+	flags := uint(lmdb.NoTLS)
+	if db.readOnly {
+		flags |= lmdb.Readonly
+	}
+	if db.noLock {
+		flags |= lmdb.NoLock
+	}
+	err = env.Open(path, flags, 0644)
+	if err != nil {
+		env.Close()
+		return err
+	}
+	db.env = env
+	return nil
+}
+
+// openDBI selects the root db for an empty name, or a named sub-database
+func (db *lmdbDB) openDBI(name string, create bool) error {
+	var flags uint
+	if create {
+		flags = lmdb.Create
+	}
+	op := func(txn *lmdb.Txn) (err error) {
+		var dbi lmdb.DBI
+		if name == "" {
+			dbi, err = txn.OpenRoot(flags)
+		} else {
+			dbi, err = txn.OpenDBI(name, flags)
+		}
+		if lmdb.IsNotFound(err) {
+			return fmt.Errorf("No such sub-database: %s", name)
+		}
+		if err == nil {
+			db.dbi, db.name = dbi, name
+		}
+		return err
+	}
+	if db.readOnly {
+		return db.env.View(op)
+	}
+	return db.env.Update(op)
+}
+
+// Buckets lists the named sub-databases, the root db is "". They are stored as keys of
+// the root db, the listing stops at the first key that isn't one
+func (db *lmdbDB) Buckets() (buckets []string, err error) {
+	buckets = []string{""}
+	err = db.env.View(func(txn *lmdb.Txn) error {
+		root, err := txn.OpenRoot(0)
+		if err != nil {
+			return err
+		}
+		cursor, err := txn.OpenCursor(root)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+		for k, _, err := cursor.Get(nil, nil, lmdb.First); err == nil; k, _, err = cursor.Get(nil, nil, lmdb.Next) {
+			if _, err := txn.OpenDBI(string(k), 0); err != nil {
+				break
+			}
+			buckets = append(buckets, string(k))
+		}
+		return nil
+	})
+	return
+}
+
+// SetBucket selects a named sub-database, "" for the root one, and resets the iterator
+func (db *lmdbDB) SetBucket(name string) error {
+	db.Release()
+	dbi, old := db.dbi, db.name
+	err := db.openDBI(name, false)
+	if err != nil {
+		db.dbi, db.name = dbi, old
+	}
+	scanErr := db.Scan()
+	if err != nil {
+		return err
+	}
+	return scanErr
+}
+
+// Bucket returns the name of the selected sub-database
+func (db *lmdbDB) Bucket() string {
+	return db.name
+}
+
+// update runs a write transaction, growing the map and trying again when it is full
+func (db *lmdbDB) update(op lmdb.TxnOp) error {
+	if db.readOnly {
+		return errors.New("Opened read-only")
+	}
+	for {
+		err := db.env.Update(op)
+		if !lmdb.IsMapFull(err) || !db.grow {
+			return err
+		}
+		err = db.growMap()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// growMap doubles the map size, the read transaction of the iterator has to be closed
+// for that and the iterator is put back at the same key
+func (db *lmdbDB) growMap() error {
+	info, err := db.env.Info()
+	if err != nil {
+		return err
+	}
+	iterating := db.txn != nil
+	key := append([]byte(nil), db.key...)
+	db.Release()
+	err = db.env.SetMapSize(info.MapSize * 2)
+	if err != nil {
+		return fmt.Errorf("Could not grow the map to %v bytes: %v", info.MapSize*2, err)
+	}
+	if !iterating {
+		return nil
+	}
+	if len(key) == 0 {
+		return db.Scan()
+	}
+	return db.Seek(key)
+}
+
+func (db *lmdbDB) Scan() (err error) {
+	if db.txn != nil {
+		return
+	}
+	db.txn, err = db.env.BeginTxn(nil, lmdb.Readonly)
+	if lmdb.IsMapResized(err) {
+		// another process grew the map, adopt its size
+		err = db.env.SetMapSize(0)
+		if err == nil {
+			db.txn, err = db.env.BeginTxn(nil, lmdb.Readonly)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("Could not start transaction: %v", err)
+	}
+	db.cursor, err = db.txn.OpenCursor(db.dbi)
+	if err != nil {
+		db.txn.Abort()
+		db.txn = nil
+		return fmt.Errorf("Could not open cursor: %v", err)
+	}
+	return db.Reset()
+}
+
+func (db *lmdbDB) Reset() (err error) {
+	if db.cursor == nil {
+		return db.Scan()
+	}
+	db.key, db.value, err = db.cursor.Get(nil, nil, lmdb.First)
+	if lmdb.IsNotFound(err) {
+		// empty db
+		return nil
+	}
+	return
+}
+
+func (db *lmdbDB) Seek(k []byte) (err error) {
+	if db.cursor == nil {
+		err = db.Scan()
+		if err != nil {
+			return
+		}
+	}
+	db.key, db.value, err = db.cursor.Get(k, nil, lmdb.SetRange)
+	if lmdb.IsNotFound(err) {
+		// past the last key
+		db.key, db.value = nil, nil
+		return nil
+	}
+	return
+}
+
+func (db *lmdbDB) Next() bool {
+	var err error
+	db.key, db.value, err = db.cursor.Get(nil, nil, lmdb.Next)
+	return err == nil
+}
+
+func (db *lmdbDB) Last() (err error) {
+	if db.cursor == nil {
+		err = db.Scan()
+		if err != nil {
+			return
+		}
+	}
+	db.key, db.value, err = db.cursor.Get(nil, nil, lmdb.Last)
+	if lmdb.IsNotFound(err) {
+		// empty db
+		return nil
+	}
+	return
+}
+
+func (db *lmdbDB) Prev() bool {
+	var err error
+	db.key, db.value, err = db.cursor.Get(nil, nil, lmdb.Prev)
+	return err == nil
+}
+
+// Follow starts a new read transaction, which sees the records written since the last
+// one, and moves to the first key after last
+func (db *lmdbDB) Follow(last []byte) bool {
+	db.Release()
+	if db.Scan() != nil || db.key == nil {
+		return false
+	}
+	if len(last) == 0 {
+		return true
+	}
+	if db.Seek(last) != nil || db.key == nil {
+		return false
+	}
+	if bytes.Equal(db.key, last) {
+		return db.Next()
+	}
+	return true
+}
+
+func (db *lmdbDB) Key() []byte {
+	return db.key
+}
+
+func (db *lmdbDB) Value() []byte {
+	return db.value
+}
+
+// GetRandom picks a record index uniformly and walks a cursor there from the nearer
+// end. Values aren't copied while walking, only the picked record is
+func (db *lmdbDB) GetRandom() (key []byte, value []byte, err error) {
+	err = db.env.View(func(txn *lmdb.Txn) error {
+		txn.RawRead = true
+		stat, err := txn.Stat(db.dbi)
+		if err != nil {
+			return err
+		}
+		if stat.Entries == 0 {
+			return errors.New("Empty db")
+		}
+		cursor, err := txn.OpenCursor(db.dbi)
+		if err != nil {
+			return err
+		}
+		defer cursor.Close()
+		i := uint64(rand.Int63n(int64(stat.Entries)))
+		start, step := lmdb.First, lmdb.Next
+		if i >= stat.Entries/2 {
+			start, step, i = lmdb.Last, lmdb.Prev, stat.Entries-1-i
+		}
+		k, v, err := cursor.Get(nil, nil, start)
+		for ; err == nil && i > 0; i-- {
+			k, v, err = cursor.Get(nil, nil, step)
+		}
+		if err != nil {
+			return err
+		}
+		key = append([]byte{}, k...)
+		value = append([]byte{}, v...)
+		return nil
+	})
+	return
+}
+
+func (db *lmdbDB) Get(key []byte) (value []byte, err error) {
+	err = db.env.View(func(txn *lmdb.Txn) (err error) {
+		v, err := txn.Get(db.dbi, key)
+		value = make([]byte, len(v))
+		copy(value, v)
+
+		return err
+	})
+	return
+}
+
+func (db *lmdbDB) Put(key []byte, value []byte) error {
+	return db.update(func(txn *lmdb.Txn) (err error) {
+		return txn.Put(db.dbi, key, value, 0)
+	})
+}
+
+func (db *lmdbDB) Delete(key []byte) error {
+	return db.update(func(txn *lmdb.Txn) (err error) {
+		return txn.Del(db.dbi, key, nil)
+	})
+}
+
+// WriteBatch applies all ops in one write transaction
+func (db *lmdbDB) WriteBatch(ops []BatchOp) error {
+	return db.update(func(txn *lmdb.Txn) (err error) {
+		for _, op := range ops {
+			if op.Delete {
+				err = txn.Del(db.dbi, op.Key, nil)
+				if lmdb.IsNotFound(err) {
+					err = nil
+				}
+			} else {
+				err = txn.Put(db.dbi, op.Key, op.Value, 0)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dbiStat returns the statistics of the selected (sub-)database
+func (db *lmdbDB) dbiStat() (stat *lmdb.Stat, err error) {
+	err = db.env.View(func(txn *lmdb.Txn) (err error) {
+		stat, err = txn.Stat(db.dbi)
+		return err
+	})
+	return
+}
+
+func (db *lmdbDB) Entries() uint64 {
+	stat, err := db.dbiStat()
+	if err != nil {
+		return 0
+	}
+	return stat.Entries
+}
+
+func (db *lmdbDB) Stat() ([]Property, error) {
+	stat, err := db.dbiStat()
+	if err != nil {
+		return nil, err
+	}
+	info, err := db.env.Info()
+	if err != nil {
+		return nil, err
+	}
+	return []Property{
+		{"page size", fmt.Sprintf("%v bytes", stat.PSize)},
+		{"tree depth", fmt.Sprint(stat.Depth)},
+		{"branch pages", fmt.Sprint(stat.BranchPages)},
+		{"leaf pages", fmt.Sprint(stat.LeafPages)},
+		{"overflow pages", fmt.Sprint(stat.OverflowPages)},
+		{"map size", fmt.Sprintf("%v bytes", info.MapSize)},
+		{"map used", fmt.Sprintf("%v bytes", (info.LastPNO+1)*int64(stat.PSize))},
+		{"last transaction", fmt.Sprint(info.LastTxnID)},
+		{"readers", fmt.Sprintf("%v of %v", info.NumReaders, info.MaxReaders)},
+		{"mode", db.mode()},
+		{"sub-database", db.name},
+	}, nil
+}
+
+func (db *lmdbDB) Release() {
+	if db.cursor != nil {
+		db.cursor.Close()
+		db.cursor = nil
+	}
+	if db.txn != nil {
+		db.txn.Abort()
+		db.txn = nil
+	}
+}
+
+func (db *lmdbDB) Close() error {
+	db.Release()
+	if db.shared {
+		return nil
+	}
+	return db.env.Close()
+}
+
+// NewCursor returns an iterator with a read transaction of its own on the same env
+func (db *lmdbDB) NewCursor() (Backend, error) {
+	return &lmdbDB{dbi: db.dbi, env: db.env, name: db.name, readOnly: db.readOnly, noLock: db.noLock, grow: db.grow, shared: true}, nil
+}
+
+// mode describes how the env was opened
+func (db *lmdbDB) mode() string {
+	m := "read-write"
+	if db.readOnly {
+		m = "read-only"
+	}
+	if db.noLock {
+		return m + ", no locking"
+	}
+	return m + ", locking"
+}
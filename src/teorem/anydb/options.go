@@ -0,0 +1,91 @@
+package anydb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options are backend settings given to OpenWith and CreateWith, like readonly or
+// mapsize for lmdb. Flags are stored with an empty value
+type Options map[string]string
+
+// ParseOptions reads words like "readonly" or "mapsize=10g"
+func ParseOptions(words []string) Options {
+	o := make(Options)
+	for _, w := range words {
+		kv := strings.SplitN(w, "=", 2)
+		name := strings.ToLower(kv[0])
+		if len(kv) == 2 {
+			o[name] = kv[1]
+		} else {
+			o[name] = ""
+		}
+	}
+	return o
+}
+
+// Bool is true if the flag name is set, and not set to off or false
+func (o Options) Bool(name string) bool {
+	v, ok := o[name]
+	if !ok {
+		return false
+	}
+	switch strings.ToLower(v) {
+	case "off", "false", "no", "0":
+		return false
+	}
+	return true
+}
+
+// Get returns the value of name, or def if it isn't set
+func (o Options) Get(name string, def string) string {
+	v, ok := o[name]
+	if !ok || v == "" {
+		return def
+	}
+	return v
+}
+
+// Size returns the value of name in bytes, it can end with k, m, g or t
+// def is returned if it isn't set
+func (o Options) Size(name string, def int64) (int64, error) {
+	v, ok := o[name]
+	if !ok || v == "" {
+		return def, nil
+	}
+	v = strings.ToLower(v)
+	scale := int64(1)
+	switch v[len(v)-1] {
+	case 'k':
+		scale = 1 << 10
+	case 'm':
+		scale = 1 << 20
+	case 'g':
+		scale = 1 << 30
+	case 't':
+		scale = 1 << 40
+	}
+	if scale != 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("Malformed size for %v: %v", name, o[name])
+	}
+	return n * scale, nil
+}
+
+func (o Options) String() string {
+	words := make([]string, 0, len(o))
+	for name, v := range o {
+		if v == "" {
+			words = append(words, name)
+		} else {
+			words = append(words, name+"="+v)
+		}
+	}
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,69 @@
+package anydb
+
+import (
+	"bytes"
+	"math/big"
+	"math/rand"
+)
+
+// randomKey returns a key between first and last, picked uniformly in the byte space
+// of their padded values. Keys don't fill it evenly so the record found by seeking
+// there isn't uniformly picked, only use it where that doesn't matter
+func randomKey(first []byte, last []byte) []byte {
+	size := len(first)
+	if len(last) > size {
+		size = len(last)
+	}
+	a := new(big.Int).SetBytes(pad(first, size))
+	b := new(big.Int).SetBytes(pad(last, size))
+	span := new(big.Int).Sub(b, a)
+	if span.Sign() <= 0 {
+		return first
+	}
+	// 8 extra random bytes make the bias of the modulo negligible
+	r := make([]byte, size+8)
+	rand.Read(r)
+	k := new(big.Int).SetBytes(r)
+	k.Mod(k, span)
+	k.Add(k, a)
+	key := make([]byte, size)
+	kb := k.Bytes()
+	copy(key[size-len(kb):], kb)
+	return key
+}
+
+// randomByDescent picks a key by going down the keys one byte at a time: the bytes
+// found after the prefix so far are listed with seek and one of them is picked, a
+// prefix that is a key itself counts as one more choice. The pick is uniform when
+// the keys under every prefix are about as many, like sequential ids, whatever
+// alphabet they use. seek returns the first key >= k, nil past the last one
+func randomByDescent(seek func(k []byte) []byte) []byte {
+	prefix := []byte{}
+	for {
+		var next []int
+		k := seek(prefix)
+		for k != nil && bytes.HasPrefix(k, prefix) {
+			after := make([]byte, len(prefix)+1)
+			copy(after, prefix)
+			if len(k) == len(prefix) {
+				next = append(next, -1)
+			} else {
+				c := k[len(prefix)]
+				next = append(next, int(c))
+				if c == 0xff {
+					break
+				}
+				after[len(prefix)] = c + 1
+			}
+			k = seek(after)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		c := next[rand.Intn(len(next))]
+		if c < 0 {
+			return prefix
+		}
+		prefix = append(prefix[:len(prefix):len(prefix)], byte(c))
+	}
+}
@@ -0,0 +1,126 @@
+package anydb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Sequential decimal keys like the ones caffe writes only use 10 of the 256 values of
+// each byte, seeking to a random key picked most records ending in 9
+func TestGetRandomDecimalKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name   string
+		create func(path string, o Options) (Backend, error)
+		open   func(path string, o Options) (Backend, error)
+	}{
+		// reopening moves the records from the journal to a table, so sizes are known
+		{"leveldb", createLevelDB, openLevelDB},
+		{"bolt", createBolt, openBolt},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		b, err := test.create(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		const records = 10000
+		ops := make([]BatchOp, records)
+		for i := range ops {
+			// incompressible values of a realistic size, a leveldb block holds a few
+			// records and the last one of a table, which also gets the size of the
+			// table index, is a small part of the table
+			value := make([]byte, 200)
+			rand.Read(value)
+			value[0] = byte(i)
+			ops[i] = BatchOp{Key: []byte(fmt.Sprintf("%08d", i)), Value: value}
+		}
+		err = b.(Batcher).WriteBatch(ops)
+		b.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err = test.open(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db := b.(RandomGetter)
+
+		const draws = 20000
+		lastDigit := make([]int, 10)
+		thousands := make([]int, 10)
+		for i := 0; i < draws; i++ {
+			key, value, err := db.GetRandom()
+			if err != nil {
+				t.Fatalf("%v: %v", test.name, err)
+			}
+			var n int
+			if _, err = fmt.Sscanf(string(key), "%08d", &n); err != nil {
+				t.Fatalf("%v: %q: %v", test.name, key, err)
+			}
+			if len(value) != 200 || value[0] != byte(n) {
+				t.Fatalf("%v: %q: value %v", test.name, key, value[0])
+			}
+			lastDigit[n%10]++
+			thousands[n/1000]++
+		}
+		b.Close()
+		// the expected count is 2000 with a standard deviation of about 42
+		for d := 0; d < 10; d++ {
+			if lastDigit[d] < 1700 || lastDigit[d] > 2300 {
+				t.Errorf("%v: keys ending in %v drawn %v times out of %v", test.name, d, lastDigit[d], draws)
+			}
+			if thousands[d] < 1700 || thousands[d] > 2300 {
+				t.Errorf("%v: keys %v000-%v999 drawn %v times out of %v", test.name, d, d, thousands[d], draws)
+			}
+		}
+	}
+}
+
+func TestRandomByDescent(t *testing.T) {
+	tests := []struct {
+		keys []string
+		want []string
+	}{
+		{nil, nil},
+		{[]string{"a"}, []string{"a"}},
+		// a key that is a prefix of others
+		{[]string{"a", "ab", "ac"}, []string{"a", "ab", "ac"}},
+		{[]string{"", "\xff", "\xff\xff"}, []string{"", "\xff", "\xff\xff"}},
+	}
+	for _, test := range tests {
+		seek := func(k []byte) []byte {
+			i := sort.SearchStrings(test.keys, string(k))
+			if i == len(test.keys) {
+				return nil
+			}
+			return []byte(test.keys[i])
+		}
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			key := randomByDescent(seek)
+			if key == nil {
+				break
+			}
+			seen[string(key)] = true
+		}
+		var got []string
+		for k := range seen {
+			got = append(got, k)
+		}
+		sort.Strings(got)
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.want) {
+			t.Errorf("%q: drew %q", test.keys, got)
+		}
+	}
+}
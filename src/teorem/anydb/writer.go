@@ -0,0 +1,162 @@
+package anydb
+
+import (
+	"fmt"
+)
+
+// Default limits for a Writer, a batch is committed when either is reached
+const (
+	DefaultBatchRecords = 1000
+	DefaultBatchBytes   = 64 << 20
+)
+
+// BatchOp is a single write in a batch
+type BatchOp struct {
+	Key    []byte
+	Value  []byte
+	Delete bool
+}
+
+// Batcher is implemented by backends that can apply several writes in one transaction
+type Batcher interface {
+	WriteBatch(ops []BatchOp) error
+}
+
+// CommitError is returned by a Writer when a commit fails
+// Records is the number of records of the batch that were lost, all of them for
+// Batcher backends, those from the failed write on for the others
+type CommitError struct {
+	Commit  int
+	Records int
+	Err     error
+}
+
+func (e *CommitError) Error() string {
+	return fmt.Sprintf("commit %v failed, %v records lost: %v", e.Commit, e.Records, e.Err)
+}
+
+// Writer buffers writes to a db and commits them in batches
+// Backends without batch support get one Put or Delete per record at commit, so a
+// batch is only atomic for Batcher backends
+// A Writer is not safe for concurrent use
+type Writer struct {
+	db         *ADB
+	maxRecords int
+	maxBytes   int
+
+	ops   []BatchOp
+	bytes int
+
+	commits uint64
+	written uint64
+	failed  uint64
+}
+
+// NewWriter returns a Writer committing every maxRecords records or maxBytes bytes
+// Zero or negative limits means the defaults
+func (db *ADB) NewWriter(maxRecords int, maxBytes int) *Writer {
+	if maxRecords <= 0 {
+		maxRecords = DefaultBatchRecords
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultBatchBytes
+	}
+	return &Writer{
+		db:         db,
+		maxRecords: maxRecords,
+		maxBytes:   maxBytes,
+		ops:        make([]BatchOp, 0, maxRecords),
+	}
+}
+
+// Put buffers a write of value to key
+// If this fills the batch it is committed, and a commit failure returned
+func (w *Writer) Put(key []byte, value []byte) error {
+	// copy, the caller might reuse or lose the buffers (like lmdb values)
+	op := BatchOp{
+		Key:   append([]byte(nil), key...),
+		Value: append([]byte(nil), value...),
+	}
+	return w.add(op)
+}
+
+// Delete buffers a removal of key
+func (w *Writer) Delete(key []byte) error {
+	return w.add(BatchOp{Key: append([]byte(nil), key...), Delete: true})
+}
+
+func (w *Writer) add(op BatchOp) error {
+	w.ops = append(w.ops, op)
+	w.bytes += len(op.Key) + len(op.Value)
+	if len(w.ops) >= w.maxRecords || w.bytes >= w.maxBytes {
+		return w.Flush()
+	}
+	return nil
+}
+
+// Flush commits all buffered writes
+func (w *Writer) Flush() error {
+	if len(w.ops) == 0 {
+		return nil
+	}
+	w.commits++
+	done, err := w.commit()
+	lost := len(w.ops) - done
+	w.ops = w.ops[:0]
+	w.bytes = 0
+	w.written += uint64(done)
+	if err != nil {
+		w.failed += uint64(lost)
+		return &CommitError{Commit: int(w.commits), Records: lost, Err: err}
+	}
+	return nil
+}
+
+// commit writes the buffered ops and returns how many of them were written
+func (w *Writer) commit() (int, error) {
+	if b, ok := w.db.backend.(Batcher); ok {
+		err := b.WriteBatch(w.ops)
+		if err != nil {
+			return 0, err
+		}
+		return len(w.ops), nil
+	}
+	for i, op := range w.ops {
+		var err error
+		if op.Delete {
+			err = w.db.Delete(op.Key)
+		} else {
+			err = w.db.Put(op.Key, op.Value)
+		}
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(w.ops), nil
+}
+
+// Abort drops all buffered writes not yet committed
+func (w *Writer) Abort() {
+	w.ops = w.ops[:0]
+	w.bytes = 0
+}
+
+// Pending returns number of buffered records
+func (w *Writer) Pending() int {
+	return len(w.ops)
+}
+
+// Written returns number of successfully committed records
+func (w *Writer) Written() uint64 {
+	return w.written
+}
+
+// Failed returns number of records lost in failed commits
+func (w *Writer) Failed() uint64 {
+	return w.failed
+}
+
+// Commits returns number of commits so far
+func (w *Writer) Commits() uint64 {
+	return w.commits
+}
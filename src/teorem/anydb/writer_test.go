@@ -0,0 +1,115 @@
+package anydb
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriterFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tests := []struct {
+		maxRecords int
+		maxBytes   int
+		puts       int
+		// value size of every put, keys are 3 bytes
+		size    int
+		commits uint64
+		pending int
+	}{
+		{0, 0, 10, 1, 0, 10},
+		{5, 0, 12, 1, 2, 2},
+		{5, 0, 10, 1, 2, 0},
+		// batches fill up on bytes first
+		{100, 20, 10, 7, 5, 0},
+		{100, 20, 9, 7, 4, 1},
+		{1, 1, 3, 0, 3, 0},
+	}
+	for n, test := range tests {
+		db, err := Create(filepath.Join(dir, fmt.Sprint(n)), "folder")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := db.NewWriter(test.maxRecords, test.maxBytes)
+		for i := 0; i < test.puts; i++ {
+			err = w.Put([]byte(fmt.Sprintf("k%02d", i)), make([]byte, test.size))
+			if err != nil {
+				t.Fatalf("%+v: %v", test, err)
+			}
+		}
+		if w.Commits() != test.commits || w.Pending() != test.pending {
+			t.Errorf("%+v: %v commits and %v pending", test, w.Commits(), w.Pending())
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("%+v: %v", test, err)
+		}
+		if w.Pending() != 0 || w.Written() != uint64(test.puts) || w.Failed() != 0 {
+			t.Errorf("%+v: %v pending, %v written and %v failed after flush", test, w.Pending(), w.Written(), w.Failed())
+		}
+		if e := db.Entries(); e != uint64(test.puts) {
+			t.Errorf("%+v: %v files", test, e)
+		}
+		db.Close()
+	}
+}
+
+func TestWriterCommitError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "anydb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "gone")
+	db, err := Create(path, "folder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	w := db.NewWriter(3, 0)
+	for _, k := range []string{"a", "b", "c"} {
+		if err = w.Put([]byte(k), []byte(k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a failure part way through the batch loses the records from there on
+	w.Put([]byte("x"), []byte("x"))
+	w.Put([]byte("a/b"), []byte("x"))
+	// the third record fills the batch
+	err = w.Put([]byte("y"), []byte("y"))
+	if ce, ok := err.(*CommitError); !ok || ce.Commit != 2 || ce.Records != 2 {
+		t.Fatalf("got %v, want commit 2 with 2 records lost", err)
+	}
+	if w.Written() != 4 || w.Failed() != 2 {
+		t.Errorf("%v written, %v failed", w.Written(), w.Failed())
+	}
+
+	// writes fail once the folder is removed
+	os.RemoveAll(path)
+	w.Put([]byte("d"), []byte("d"))
+	w.Delete([]byte("a"))
+	err = w.Flush()
+	ce, ok := err.(*CommitError)
+	if !ok {
+		t.Fatalf("got %v, want a CommitError", err)
+	}
+	if ce.Commit != 3 || ce.Records != 2 || ce.Err == nil {
+		t.Errorf("got %+v, want commit 3 with 2 records", ce)
+	}
+	if w.Written() != 4 || w.Failed() != 4 || w.Pending() != 0 {
+		t.Errorf("%v written, %v failed, %v pending", w.Written(), w.Failed(), w.Pending())
+	}
+
+	// aborted writes are dropped without a commit
+	w.Put([]byte("e"), []byte("e"))
+	w.Abort()
+	if err = w.Flush(); err != nil || w.Commits() != 3 {
+		t.Errorf("flush after abort: %v, %v commits", err, w.Commits())
+	}
+}
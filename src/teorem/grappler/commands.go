@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"teorem/anydb"
 	"teorem/grappler/caffe"
 	"teorem/grappler/vars"
 	"teorem/matlab"
@@ -19,8 +20,6 @@ import (
 	"teorem/tinyprompt"
 	"time"
 
-	"image"
-
 	"github.com/disintegration/imaging"
 	"github.com/golang/protobuf/proto"
 	"github.com/gonum/matrix/mat64"
@@ -41,16 +40,30 @@ switcher:
 
 	case "put":
 		if len(parts) < 2 {
-			fmt.Printf("Usage: put <keys>,<values> [into <namespace>.<set>]\n")
+			fmt.Printf("Usage: put <key> <value> | put <keys>,<values> [into <namespace>.<set>]\n")
 			break
 		}
-		if len(selectedDBs) != 1 {
-			fmt.Printf("Open and select ONE db first\n")
+		if len(selectedDBs) == 0 {
+			fmt.Printf("Open a db first\n")
 			break
 		}
 		v := strings.Split(parts[1], ",")
 		if len(v) != 2 {
-			fmt.Printf("Expected key,value as second parameter\n")
+			// single record, parts have been converted to lowercase, reparse it
+			parts := strings.Split(strings.Trim(text, " "), " ")
+			if len(parts) < 3 {
+				fmt.Printf("Expected key and value\n")
+				break
+			}
+			for _, db := range selectedDBs {
+				value, err := db.EncodeText(strings.Join(parts[2:], " "))
+				if err == nil {
+					err = db.Put([]byte(parts[1]), value)
+				}
+				if err != nil {
+					fmt.Printf("%s: %v\n", db.Path(), err)
+				}
+			}
 			break
 		}
 		keys, ok := matrixesChar[v[0]]
@@ -58,42 +71,121 @@ switcher:
 			fmt.Printf("No such variable %s\n", v[0])
 			break
 		}
-		values, ok := matrixes[v[1]]
-		if !ok {
+		values, isfloat := matrixes[v[1]]
+		charValues, ischar := matrixesChar[v[1]]
+		if !(isfloat || ischar) {
 			fmt.Printf("No such variable %s\n", v[1])
 			break
 		}
-		var namespace, set string
-		if len(parts) > 2 && parts[2] == "into" {
-			nss := strings.Split(parts[3], ".")
-			namespace = nss[0]
-			set = nss[1]
+		var r, c int
+		if isfloat {
+			r, c = values.Dims()
 		} else {
-			namespace = "test"
-			set = "geohashes"
+			r, _ = charValues.Dims()
 		}
-		//Aerospike GEOPoint hack!
-		r, c := values.Dims()
 		r2, _ := keys.Dims()
 		if r != r2 {
 			fmt.Printf("Row count of %v and %v doesnt match\n", v[0], v[1])
 			break
 		}
-		if c == 2 && selectedDBs[0].Identity() == "aerospike" {
+		var namespace, set string
+		if len(parts) > 3 && parts[2] == "into" {
+			nss := strings.Split(parts[3], ".")
+			if len(nss) != 2 {
+				fmt.Printf("Expected <namespace>.<set>\n")
+				break
+			}
+			namespace = nss[0]
+			set = nss[1]
+		}
+
+		for _, db := range selectedDBs {
+			if db.Identity() == "aerospike" && namespace != "" {
+				db.SetContext(namespace, set)
+			}
+			//Aerospike GEOPoint hack!
+			if isfloat && c == 2 && db.Identity() == "aerospike" {
+				if namespace == "" {
+					namespace, set = "test", "geohashes"
+				}
+				for i := 0; i < r; i++ {
+					jsonPoint := `{ "type": "Point", "coordinates": [` + strconv.FormatFloat(values.At(i, 0), 'f', -1, 64) + "," + strconv.FormatFloat(values.At(i, 1), 'f', -1, 64) + `] }`
+					//fmt.Printf("%s\n", jsonPoint)
+					err := db.PutGeoJSON(namespace, set, "point", []byte(keys.RowView(i)), jsonPoint)
+					if err != nil {
+						fmt.Printf("Aerospike error: %v", err)
+					}
+					if (i+1)%10 == 0 {
+						fmt.Printf("\r[%v:%v] Writing records...", i+1, r)
+					}
+				}
+				fmt.Printf("\r[%v:%v] Writing records... Done\n", r, r)
+				continue
+			}
+
+			writer := db.NewWriter(0, 0)
 			for i := 0; i < r; i++ {
-				jsonPoint := `{ "type": "Point", "coordinates": [` + strconv.FormatFloat(values.At(i, 0), 'f', -1, 64) + "," + strconv.FormatFloat(values.At(i, 1), 'f', -1, 64) + `] }`
-				//fmt.Printf("%s\n", jsonPoint)
-				err := selectedDBs[0].PutGeoJSON(namespace, set, "point", []byte(keys.RowView(i)), jsonPoint)
+				var value []byte
+				var err error
+				if isfloat {
+					value, err = db.EncodeFloats(values.RawRowView(i))
+				} else {
+					value = []byte(charValues.RowView(i))
+				}
+				if err == nil {
+					err = writer.Put([]byte(keys.RowView(i)), value)
+				}
 				if err != nil {
-					fmt.Printf("Aerospike error: %v", err)
+					fmt.Printf("\n%v\n", err)
+					if ce, ok := err.(*anydb.CommitError); ok && anydb.IsNotSupported(ce.Err) {
+						break
+					}
 				}
 				if (i+1)%10 == 0 {
 					fmt.Printf("\r[%v:%v] Writing records...", i+1, r)
 				}
 			}
-			fmt.Printf("\r[%v:%v] Writing records... Done\n", r, r)
+			err := writer.Flush()
+			if err != nil {
+				fmt.Printf("\n%v\n", err)
+			}
+			fmt.Printf("\r[%v:%v] Writing records... Done (%v written in %v commits)\n", r, r, writer.Written(), writer.Commits())
+		}
+
+	case "delete", "del":
+		if len(parts) != 2 {
+			fmt.Printf("Usage: delete <key> | <keys>\n")
+			break
+		}
+		if len(selectedDBs) == 0 {
+			fmt.Printf("Open a db first\n")
+			break
+		}
+		// a char matrix with keys, or a single key
+		var keys []string
+		if m, ok := matrixesChar[parts[1]]; ok {
+			r, _ := m.Dims()
+			for i := 0; i < r; i++ {
+				keys = append(keys, m.RowView(i))
+			}
 		} else {
-			fmt.Printf("Not supported yet\n")
+			// parts have been converted to lowercase, reparse it
+			keys = []string{strings.Split(strings.Trim(text, " "), " ")[1]}
+		}
+		for _, db := range selectedDBs {
+			count := 0
+			for _, k := range keys {
+				err := db.Delete([]byte(k))
+				if err != nil {
+					fmt.Printf("%s: %v\n", k, err)
+					if anydb.IsNotSupported(err) {
+						break
+					}
+					continue
+				}
+				count++
+			}
+			fmt.Printf("%v records deleted from %s\n", count, db.Path())
 		}
 
 	case "search":
@@ -162,6 +254,12 @@ switcher:
 		}
 		eval("get last")
 
+	case "prev":
+		for i := range selectedDBs {
+			selectedDBs[i].Prev()
+		}
+		eval("get last")
+
 	case "get":
 		if len(parts) < 2 {
 			fmt.Printf("Usage: get key [from <namespace>.<set>] [as <object>]\n")
@@ -210,17 +308,12 @@ switcher:
 		if len(parts) == 4 && parts[2] == "as" {
 			switch parts[3] {
 			case "image":
-				i, err := imaging.Decode(bytes.NewReader(lastValue))
+				i, err := anydb.ValueImage(lastValue, selectedDBs[0].RGB())
 				if err != nil {
 					fmt.Printf("Failed: %v", err)
 					return
 				}
-				// try it as a NRGBA
-				img, ok := i.(*image.NRGBA)
-				if !ok {
-					fmt.Printf("Not a NRGBA\n")
-					return
-				}
+				img := imaging.Clone(i)
 				bounds := img.Bounds()
 				w := bounds.Dx()
 				h := bounds.Dy()
@@ -235,13 +328,23 @@ switcher:
 			}
 		}
 
-		if selectedDBs[0].Identity() == "lmdb" || selectedDBs[0].Identity() == "leveldb" {
+		codec := selectedDBs[0].Codec(lastValue)
+		if codec.Name() == "datum" {
 			d := &caffe.Datum{}
 			err = proto.Unmarshal(lastValue, d)
 			if err != nil {
-				log.Fatal("unmarshaling error: ", err)
+				fmt.Printf("Not a caffe.Datum: %v\n", err)
+				fmt.Printf("%+v\n", lastValue)
 				break
 			}
+			if d.GetEncoded() {
+				fmt.Printf("Encoded image: %v bytes\n", len(d.GetData()))
+				err = anydb.DecodeDatumImage(d)
+				if err != nil {
+					fmt.Printf("Image does not decode: %v\n", err)
+					break
+				}
+			}
 			fmt.Printf("Channels: %v, Height: %v, Width: %v\n", d.GetChannels(), d.GetHeight(), d.GetWidth())
 			fmt.Printf("Labels: %v\n", d.GetLabel())
 			d1 := d.GetData()
@@ -263,7 +366,10 @@ switcher:
 			fmt.Printf("Data: %v bytes\nFloatData: %v float32 (%v bytes)\n", len(d1), len(lastFloats), len(lastFloats)*4)
 			fmt.Printf("Unrecognized: %v bytes\n", len(d.XXX_unrecognized))
 		} else {
-			fmt.Printf("%+v\n", lastValue)
+			fmt.Printf("%v (%v)\n", codec.Format(lastValue), codec.Name())
+			if f64, err := codec.Floats(lastValue); err == nil && len(f64) > 0 && codec.Name() != "text" {
+				matrixes["Data"] = mat64.NewDense(1, len(f64), f64)
+			}
 		}
 
 	case "clear":
@@ -277,10 +383,92 @@ switcher:
 		runtime.ReadMemStats(&stats)
 		fmt.Printf("%+v\n", stats)
 
-	case "size", "info":
-		size := selectedDBs[0].SizeOf([]byte("00000000"), []byte("99999999"))
-		mb := size / (1024 * 1024)
-		fmt.Printf("Approximate size whole db: %v Mb\n", mb)
+	case "size":
+		for _, db := range selectedDBs {
+			size, err := db.SizeOf(db.Range())
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				continue
+			}
+			start, end := db.Range()
+			fmt.Printf("%s: approximate size of [%s, %s): %.2f Mb\n", db.Path(), printableKey(start, "first"), printableKey(end, "last"), float64(size)/(1024*1024))
+		}
+
+	case "info":
+		for _, db := range selectedDBs {
+			info, err := db.Info(100)
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				continue
+			}
+			printInfo(info)
+		}
+
+	case "sample":
+		if len(parts) != 4 && len(parts) != 2 {
+			fmt.Printf("usage: sample <n> [as <object>] \n")
+			break
+		}
+		if len(selectedDBs) != 1 {
+			fmt.Printf("Select one db with \"use\"\n")
+			break
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 {
+			fmt.Printf("usage: sample <n> [as <object>] \n")
+			break
+		}
+		mat := "sample"
+		if len(parts) == 4 {
+			mat = parts[3]
+		}
+
+		// keys go to a char matrix, values that can be read as floats to a float matrix
+		// when the first one can. Records whose values then don't fit its columns are
+		// skipped, so row i of both matrixes is the same record
+		keys := matchar.NewMatchar(nil)
+		var floats *mat64.Dense
+		count, skipped := 0, 0
+		for count < n && skipped <= n && !InterruptRequested {
+			key, value, err := selectedDBs[0].GetRandom()
+			if err != nil {
+				fmt.Printf("\n%v\n", err)
+				break
+			}
+			f64, err := selectedDBs[0].Floats(value)
+			if count == 0 && err == nil && len(f64) > 0 {
+				floats = mat64.NewDense(n, len(f64), nil)
+			}
+			if floats != nil {
+				if _, c := floats.Dims(); err != nil || c != len(f64) {
+					skipped++
+					continue
+				}
+				floats.SetRow(count, f64)
+			}
+			keys.Append(string(selectedDBs[0].MapKey(key)))
+			count++
+			if count%10 == 0 {
+				fmt.Printf("\r[%v:%v] Sampling records...", count, n)
+			}
+		}
+		fmt.Printf("\r[%v:%v] Sampling records... Done\n", count, n)
+		if skipped > 0 {
+			_, c := floats.Dims()
+			fmt.Printf("Skipped %v records whose values aren't %v floats\n", skipped, c)
+		}
+		if count == 0 {
+			break
+		}
+		matrixesChar[mat+"keys"] = keys
+		printCharMatrix(mat + "keys")
+		if floats != nil {
+			if r, c := floats.Dims(); count < r {
+				floats = mat64.DenseCopyOf(floats.View(0, 0, count, c))
+			}
+			matrixes[mat] = floats
+			printMatrix(mat)
+		}
 
 	case "load":
 		if len(parts) != 4 && len(parts) != 2 {
@@ -313,7 +501,7 @@ switcher:
 			} else {
 				matrixesChar[mat].Reset()
 			}
-		case "floats", "floatdata":
+		case "floats", "floatdata", "labels":
 			_, ok := matrixes[mat]
 			if !ok {
 				a := mat64.NewDense(0, 0, nil)
@@ -325,15 +513,26 @@ switcher:
 		destReady := false
 
 		selectedDBs[0].Reset() //reset cursor to start
+		if !selectedDBs[0].Valid() {
+			fmt.Printf("No records found\n")
+			break
+		}
 
 		var count uint64
-		var max uint64
+		// an estimated max is only used for progress, loading stops at the end of the db
+		max, estimated := selectedDBs[0].EstimateEntries()
 		if limit != 0 {
-			max = limit
-		} else {
-			max = selectedDBs[0].Entries()
+			max, estimated = limit, false
+		}
+		total := progressTotal(max, estimated)
+		// with a key range the number of records is unknown, grow the matrix as we go
+		rows := int(max)
+		if start, end := selectedDBs[0].Range(); (start != nil || end != nil) && rows > 1024 {
+			rows = 1024
+		}
+		if rows < 1 {
+			rows = 1
 		}
-		count = 0
 
 	load_loop:
 		for {
@@ -342,62 +541,53 @@ switcher:
 				key := selectedDBs[0].Key()
 				matrixesChar[mat].Append(string(key))
 
-			case "floats", "floatdata":
-
-				/*	for i := 1; i < len(row); i++ {
-					f, err := strconv.ParseFloat(row[i], 64)
-					if err != nil {
-						f = 0
-					}
-					db.fileFloats = append(db.fileFloats, f)*/
-
+			case "floats", "floatdata", "labels":
 				var f64 []float64
 				var err error
-				if selectedDBs[0].Identity() == "lmdb" {
-					value := selectedDBs[0].Value()
-					d := &caffe.Datum{}
-					err = proto.Unmarshal(value, d)
-					if err != nil {
-						fmt.Printf("unmarshaling error\n")
-						break
-					}
-					floats := d.GetFloatData()
-					f64 = make([]float64, len(floats))
-					for i, v := range floats {
-						f64[i] = float64(v)
-					}
+				if parts[1] == "labels" {
+					var label int32
+					label, err = recordLabel(selectedDBs[0], selectedDBs[0].Backend().Key(), selectedDBs[0].Value())
+					f64 = []float64{float64(label)}
+				} else {
+					f64, err = selectedDBs[0].Floats(selectedDBs[0].Value())
 				}
-				if selectedDBs[0].Identity() == "file" {
-					// space seperated list with floats
-					value := string(selectedDBs[0].Value())
-					floats := strings.Split(value, " ")
-					f64 = make([]float64, len(floats))
-					for i := range floats {
-						f64[i], err = strconv.ParseFloat(floats[i], 64)
-					}
+				if err != nil {
+					fmt.Printf("unmarshaling error\n")
+					break
 				}
 
 				if !destReady {
-					matrixes[mat] = matrixes[mat].Grow(int(max), len(f64)).(*mat64.Dense)
+					matrixes[mat] = matrixes[mat].Grow(rows, len(f64)).(*mat64.Dense)
 					destReady = true
+				} else if int(count) >= rows {
+					matrixes[mat] = matrixes[mat].Grow(rows, 0).(*mat64.Dense)
+					rows *= 2
 				}
 				matrixes[mat].SetRow(int(count), f64)
 			}
 
 			count++
 			if count%10 == 0 {
-				fmt.Printf("\r[%v:%v] Loading records...", count, max)
+				fmt.Printf("\r[%v:%v] Loading records...", count, total)
 			}
-			if count >= max {
+			if !estimated && max != 0 && count >= max {
 				break load_loop
 			}
 			if !selectedDBs[0].Next() {
 				break load_loop
 			}
 		}
-		fmt.Printf("\r[%v:%v] Loading records... Done\n", count, max)
+		fmt.Printf("\r[%v:%v] Loading records... Done\n", count, total)
 
-		if parts[1] == "floats" {
+		// drop rows not used
+		if parts[1] != "keys" && destReady {
+			r, c := matrixes[mat].Dims()
+			if int(count) < r {
+				matrixes[mat] = mat64.DenseCopyOf(matrixes[mat].View(0, 0, int(count), c))
+			}
+		}
+
+		if parts[1] == "floats" || parts[1] == "labels" {
 			printMatrix(mat)
 		} else if parts[1] == "keys" {
 			printCharMatrix(mat)
@@ -405,6 +595,36 @@ switcher:
 
 		selectedDBs[0].Reset()
 
+	case "follow":
+		if len(parts) > 4 {
+			fmt.Printf("usage: follow [n] [as <object>]\n")
+			break
+		}
+		if len(selectedDBs) != 1 {
+			fmt.Printf("Select one db first\n")
+			break
+		}
+		var n int
+		var mat string
+		args := parts[1:]
+		if len(args) == 1 || len(args) == 3 {
+			var err error
+			n, err = strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				fmt.Printf("Malformed integer\n")
+				break
+			}
+			args = args[1:]
+		}
+		if len(args) == 2 {
+			if args[0] != "as" {
+				fmt.Printf("usage: follow [n] [as <object>]\n")
+				break
+			}
+			mat = args[1]
+		}
+		followDB(selectedDBs[0], n, mat)
+
 	case "generate":
 		if len(parts) < 5 || parts[1] != "siamese" || parts[2] != "dataset" {
 			fmt.Printf("Usage:\nGENERATE SIAMESE DATASET <db> (<width>,<height>) [with operation,operation,...]\n")
@@ -437,6 +657,216 @@ switcher:
 		}
 		generateSiameseDataset(parts[3], destW, destH, todo)
 
+	case "copy":
+		// parts have been converted to lowercase, reparse it
+		args := strings.Fields(text)
+		if len(args) < 4 || strings.ToLower(args[2]) != "to" {
+			fmt.Printf("usage: copy <id> to <type>:<path> [from <key>] [until <key>] [keys s/pattern/replacement/] [values /regex/] [resume] [datum]\n")
+			break
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id < 0 || id >= len(allDBs) {
+			fmt.Printf("no such id\n")
+			break
+		}
+		dest := strings.SplitN(args[3], ":", 2)
+		if len(dest) != 2 {
+			fmt.Printf("Destination must be <type>:<path>\n")
+			break
+		}
+		o, err := parseCopyOptions(args[4:])
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			break
+		}
+		copyDB(allDBs[id], strings.ToLower(dest[0]), dest[1], o)
+
+	case "shuffle":
+		// parts have been converted to lowercase, reparse it
+		args := strings.Fields(text)
+		if len(args) < 4 || strings.ToLower(args[2]) != "to" {
+			fmt.Printf("usage: shuffle <id> to <path> [seed <n>] [keys sequential | prefix]\n")
+			break
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id < 0 || id >= len(allDBs) {
+			fmt.Printf("no such id\n")
+			break
+		}
+		seed := time.Now().UnixNano()
+		prefix := true
+		for i := 4; i+1 < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "seed":
+				seed, err = strconv.ParseInt(args[i+1], 10, 64)
+			case "keys":
+				prefix = strings.ToLower(args[i+1]) != "sequential"
+			}
+		}
+		if err != nil {
+			fmt.Printf("Malformed seed\n")
+			break
+		}
+		shuffleDB(allDBs[id], args[3], seed, prefix)
+
+	case "split":
+		// parts have been converted to lowercase, reparse it
+		args := strings.Fields(text)
+		if len(args) < 4 || strings.ToLower(args[2]) != "into" {
+			fmt.Printf("usage: split <id> into train:80,val:10,test:10 [stratify] [seed <n>]\n")
+			break
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id < 0 || id >= len(allDBs) {
+			fmt.Printf("no such id\n")
+			break
+		}
+		splits, err := parseSplitParts(args[3])
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			break
+		}
+		seed := time.Now().UnixNano()
+		stratify := false
+		for i := 4; i < len(args); i++ {
+			switch strings.ToLower(args[i]) {
+			case "stratify":
+				stratify = true
+			case "seed":
+				if i+1 < len(args) {
+					i++
+					seed, err = strconv.ParseInt(args[i], 10, 64)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Printf("Malformed seed\n")
+			break
+		}
+		splitDB(allDBs[id], splits, stratify, seed)
+
+	case "diff":
+		if len(parts) != 3 {
+			fmt.Printf("usage: diff <id1> <id2>\n")
+			break
+		}
+		var dbs [2]*anydb.ADB
+		for i := range dbs {
+			id, err := strconv.Atoi(parts[i+1])
+			if err != nil || id < 0 || id >= len(allDBs) {
+				fmt.Printf("no such id\n")
+				return
+			}
+			dbs[i] = allDBs[id]
+		}
+		diffDB(dbs[0], dbs[1])
+
+	case "merge":
+		// parts have been converted to lowercase, reparse it
+		args := strings.Fields(text)
+		if len(args) < 3 || strings.ToLower(args[1]) != "to" {
+			fmt.Printf("usage: merge to <type>:<path> [on-conflict skip | overwrite | rename | fail] [prefix <p1>,<p2>,...]\n")
+			break
+		}
+		if len(selectedDBs) < 2 {
+			fmt.Printf("Select the dbs to merge with \"use\"\n")
+			break
+		}
+		dest := strings.SplitN(args[2], ":", 2)
+		if len(dest) != 2 {
+			fmt.Printf("Destination must be <type>:<path>\n")
+			break
+		}
+		policy, prefixes, err := parseMergeOptions(args[3:], len(selectedDBs))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			break
+		}
+		mergeDBs(selectedDBs, prefixes, strings.ToLower(dest[0]), dest[1], policy)
+
+	case "dedup":
+		if len(selectedDBs) != 1 {
+			fmt.Printf("Select one db with \"use\"\n")
+			break
+		}
+		// parts have been converted to lowercase, reparse it
+		args := strings.Fields(text)[1:]
+		o := dedupOptions{method: "exact", threshold: 5, variable: "duplicates"}
+		var err error
+		for i := 0; i < len(args) && err == nil; i++ {
+			option := strings.ToLower(args[i])
+			if _, ok := imageHashes[option]; ok || option == "exact" {
+				o.method = option
+				continue
+			}
+			if i+1 >= len(args) {
+				err = fmt.Errorf("Missing value for %v", option)
+				break
+			}
+			i++
+			switch option {
+			case "threshold":
+				o.threshold, err = strconv.Atoi(args[i])
+				if err == nil && (o.threshold < 0 || o.threshold > 63) {
+					err = fmt.Errorf("Threshold must be between 0 and 63 bits")
+				}
+			case "as":
+				o.variable = args[i]
+			case "to":
+				dest := strings.SplitN(args[i], ":", 2)
+				if len(dest) != 2 {
+					err = fmt.Errorf("Destination must be <type>:<path>")
+					break
+				}
+				o.destType, o.destPath = strings.ToLower(dest[0]), dest[1]
+			default:
+				err = fmt.Errorf("Unknown option %v", option)
+			}
+		}
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			fmt.Printf("usage: dedup [exact | ahash | dhash | phash] [threshold <bits>] [as <variable>] [to <type>:<path>]\n")
+			break
+		}
+		dedupDB(selectedDBs[0], o)
+
+	case "verify":
+		if len(parts) != 1 && len(parts) != 3 {
+			fmt.Printf("usage: verify [as <variable>]\n")
+			break
+		}
+		if len(selectedDBs) != 1 {
+			fmt.Printf("Select one db with \"use\"\n")
+			break
+		}
+		variable := "corrupt"
+		if len(parts) == 3 {
+			variable = parts[2]
+		}
+		verifyDB(selectedDBs[0], variable)
+
+	case "export":
+		// parts have been converted to lowercase, reparse it
+		args := strings.Fields(text)
+		if (len(args) != 5 && len(args) != 7) || strings.ToLower(args[2]) != "images" || strings.ToLower(args[3]) != "to" {
+			fmt.Printf("usage: export <id> images to <folder> [format png | jpg]\n")
+			break
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id < 0 || id >= len(allDBs) {
+			fmt.Printf("no such id\n")
+			break
+		}
+		format := "png"
+		if len(args) == 7 {
+			format = strings.ToLower(args[6])
+			if strings.ToLower(args[5]) != "format" || (format != "png" && format != "jpg") {
+				fmt.Printf("Format is png or jpg\n")
+				break
+			}
+		}
+		exportImages(allDBs[id], args[4], format)
+
 	case "compute":
 		if len(parts) != 2 {
 			fmt.Printf("usage: compute mean\n")
@@ -484,20 +914,56 @@ switcher:
 
 		}
 
-	case "start", "seek":
+	case "seek":
 		if len(parts) != 2 {
-			fmt.Printf("usage: start <string>\n")
+			fmt.Printf("usage: seek <key>\n")
 			break
 		}
+		// parts have been converted to lowercase, reparse it
+		key := strings.Split(strings.Trim(text, " "), " ")[1]
 		for _, db := range selectedDBs {
-			db.Seek([]byte(parts[1]))
+			err := db.Seek([]byte(key))
+			if err != nil {
+				fmt.Printf("%v\n", err)
+			}
 		}
 
-	case "end":
-		if len(parts[1]) != 2 {
-			fmt.Printf("usage: end <string>\n")
+	case "start", "end", "prefix":
+		if len(parts) != 2 {
+			fmt.Printf("usage: %s <key>\n", parts[0])
 			break
 		}
+		// parts have been converted to lowercase, reparse it
+		key := []byte(strings.Split(strings.Trim(text, " "), " ")[1])
+		for _, db := range selectedDBs {
+			start, end := db.Range()
+			var err error
+			switch parts[0] {
+			case "start":
+				err = db.SetRange(key, end)
+			case "end":
+				err = db.SetRange(start, key)
+			case "prefix":
+				err = db.SetPrefix(key)
+			}
+			if err != nil {
+				fmt.Printf("%v\n", err)
+			}
+		}
+		eval("range")
+
+	case "range":
+		if len(parts) > 2 {
+			fmt.Printf("usage: range [off]\n")
+			break
+		}
+		for _, db := range selectedDBs {
+			if len(parts) == 2 && parts[1] == "off" {
+				db.SetRange(nil, nil)
+			}
+			start, end := db.Range()
+			fmt.Printf("%s: [%s, %s)\n", db.Path(), printableKey(start, "first"), printableKey(end, "last"))
+		}
 
 	case "set":
 		if len(parts) < 2 || len(parts) > 3 {
@@ -512,8 +978,49 @@ switcher:
 				fmt.Printf("%v\n", maxPrintWidth)
 			case "debug":
 				fmt.Printf("%v\n", debugMode)
+			case "reverse":
+				for _, db := range selectedDBs {
+					fmt.Printf("%s: %v\n", db.Path(), db.Reverse())
+				}
+			case "codec":
+				for _, db := range selectedDBs {
+					fmt.Printf("%s: %v\n", db.Path(), db.CodecName())
+				}
+			case "filter":
+				for _, db := range selectedDBs {
+					if re := db.KeyFilter(); re != nil {
+						fmt.Printf("%s: /%v/\n", db.Path(), re)
+					} else {
+						fmt.Printf("%s: off\n", db.Path())
+					}
+				}
+			case "keymap":
+				for _, db := range selectedDBs {
+					if pattern, replacement := db.KeyMap(); pattern != nil {
+						fmt.Printf("%s: s/%v/%v/\n", db.Path(), pattern, replacement)
+					} else {
+						fmt.Printf("%s: off\n", db.Path())
+					}
+				}
+			case "channels":
+				for _, db := range selectedDBs {
+					order := "bgr"
+					if db.RGB() {
+						order = "rgb"
+					}
+					fmt.Printf("%s: %v\n", db.Path(), order)
+				}
 			case "limit":
 				fmt.Printf("%v\n", limit)
+			case "bucket":
+				for _, db := range selectedDBs {
+					buckets, err := db.Buckets()
+					if err != nil {
+						fmt.Printf("%v\n", err)
+						continue
+					}
+					fmt.Printf("%s: %v (using %v)\n", db.Path(), buckets, db.Bucket())
+				}
 			}
 			break
 		}
@@ -530,28 +1037,95 @@ switcher:
 			} else {
 				debugMode = false
 			}
+		//iterate backwards on/off
+		case "reverse":
+			for _, db := range selectedDBs {
+				err := db.SetReverse(parts[2] == "on")
+				if err != nil {
+					fmt.Printf("%v\n", err)
+				}
+			}
+		//how values are read as floats
+		case "codec":
+			for _, db := range selectedDBs {
+				err := db.SetCodec(parts[2])
+				if err != nil {
+					fmt.Printf("%v\n", err)
+					break
+				}
+			}
+		//channel order of Datum images, caffe uses bgr
+		case "channels":
+			if parts[2] != "rgb" && parts[2] != "bgr" {
+				fmt.Printf("usage: set channels rgb | bgr\n")
+				break
+			}
+			for _, db := range selectedDBs {
+				db.SetRGB(parts[2] == "rgb")
+			}
 		case "limit":
 			l, err := strconv.Atoi(parts[2])
 			if err != nil {
 				fmt.Printf("malformed number\n")
 			}
 			limit = uint64(l)
+		//selects bucket in a bolt db
+		case "bucket":
+			// parts have been converted to lowercase, reparse it
+			name := strings.Fields(text)[2]
+			for _, db := range selectedDBs {
+				err := db.SetBucket(name)
+				if err != nil {
+					fmt.Printf("%v\n", err)
+				}
+			}
 		//adds a filter to the key before it is printed
 		case "filter":
-			//expect [i:j], [i:], [:j]
-			parts[2] = strings.TrimPrefix(parts[2], "[")
-			parts[2] = strings.TrimSuffix(parts[2], "]")
-			ij := strings.Split(parts[2], ":")
-			i, err := strconv.Atoi(ij[0])
-			if err != nil {
-				i = 0
+			// parts have been converted to lowercase, reparse it
+			arg := strings.Fields(text)[2]
+			var re *regexp.Regexp
+			var i, j int
+			var err error
+			switch {
+			case parts[2] == "off":
+			case strings.HasPrefix(arg, "/"):
+				//expect /regex/
+				re, err = parseRegexp(arg)
+			default:
+				//expect [i:j], [i:], [:j]
+				i, j, err = parseFilterRange(arg)
 			}
-			j, err := strconv.Atoi(ij[1])
 			if err != nil {
-				j = 0
+				fmt.Printf("%v\n", err)
+				break
+			}
+			for _, db := range selectedDBs {
+				if re != nil || parts[2] == "off" {
+					err = db.SetKeyFilter(re)
+					if err != nil {
+						fmt.Printf("%v\n", err)
+					}
+				}
+				if re == nil {
+					db.SetFilterRange(i, j)
+				}
+			}
+		//rewrites keys before they are returned
+		case "keymap":
+			// parts have been converted to lowercase, reparse it
+			arg := strings.Fields(text)[2]
+			var pattern *regexp.Regexp
+			var replacement string
+			if parts[2] != "off" {
+				var err error
+				pattern, replacement, err = parseSubstitution(arg)
+				if err != nil {
+					fmt.Printf("%v\n", err)
+					break
+				}
 			}
 			for _, db := range selectedDBs {
-				db.SetFilterRange(i, j)
+				db.SetKeyMap(pattern, replacement)
 			}
 		default:
 			fmt.Printf("No such option\n")
@@ -588,6 +1162,12 @@ switcher:
 			break
 		}
 
+		// ls -n [n] pages backwards, stepping back before each record is printed
+		backwards := len(parts) > 1 && parts[1] == "-n"
+		if backwards {
+			parts = parts[1:]
+		}
+
 		var max int
 		if len(parts) == 2 {
 			max, _ = strconv.Atoi(parts[1])
@@ -601,10 +1181,16 @@ switcher:
 		for {
 
 			for _, db := range selectedDBs {
+				if backwards && !db.Prev() {
+					break list_loop
+				}
+				if !db.Valid() {
+					break list_loop
+				}
 				key := db.Key()
 				value := db.Value()
 				fmt.Printf("%s (%v bytes)        ", key, len(value))
-				if !db.Next() {
+				if !backwards && !db.Next() {
 					break list_loop
 				}
 			}
@@ -618,7 +1204,14 @@ switcher:
 
 	case "stat":
 		for _, db := range selectedDBs {
-			db.Stat()
+			stat, err := db.Stat()
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				continue
+			}
+			for _, p := range stat {
+				fmt.Printf("  %-20s %s\n", p.Name+":", p.Value)
+			}
 		}
 
 	case "q", "quit", "exit":
@@ -632,31 +1225,56 @@ switcher:
 			fmt.Printf("COMMANDS\n")
 			fmt.Printf("\n")
 			fmt.Printf("  DATABASES\n")
-			fmt.Printf("    OPEN /path/to/lmdb | /path/to/image-folder | <filename> | aerospike:<server>\n")
+			fmt.Printf("    OPEN /path/to/lmdb | /path/to/image-folder | <filename> | csv:<filename> | bolt:<filename> | aerospike:<server> [options]\n")
+			fmt.Printf("         lmdb options: readonly, nolock, nogrow, mapsize=<size>, db=<name>\n")
+			fmt.Printf("         bolt options: readonly\n")
+			fmt.Printf("         csv options: delimiter=<char> | tab | space, header | noheader, key=<column> | none,\n")
+			fmt.Printf("                      values=<column>[,<column>|<i>-<j>...] (columns by header name or index from 1)\n")
 			fmt.Printf("    CLOSE\n")
 			fmt.Printf("    DBS\n")
-			fmt.Printf("    USE <id>[,<id>]\n")
+			fmt.Printf("    USE <id>[/<sub-database>][,<id>]\n")
 			fmt.Printf("\n")
 			fmt.Printf("  ITERATOR\n")
 			fmt.Printf("    RESET\n")
-			fmt.Printf("    LS [n]\n")
+			fmt.Printf("    LS [-n] [n]\n")
+			fmt.Printf("    NEXT | PREV\n")
+			fmt.Printf("    SEEK <key>\n")
+			fmt.Printf("    START <key> | END <key> | PREFIX <key>\n")
+			fmt.Printf("    RANGE [off]\n")
 			fmt.Printf("\n")
 			fmt.Printf("  OPTIONS\n")
-			fmt.Printf("    SET start n\n")
 			fmt.Printf("    SET limit n\n")
-			fmt.Printf("    SET filter [i]:[j]\n")
-			fmt.Printf("    SET filter [i]:[j]\n")
+			fmt.Printf("    SET filter [i:j] | /regex/ | off\n")
+			fmt.Printf("    SET keymap s/pattern/replacement/ | off\n")
+			fmt.Printf("    SET bucket [name]\n")
+			fmt.Printf("    SET reverse on | off\n")
+			fmt.Printf("    SET channels rgb | bgr\n")
+			fmt.Printf("    SET codec auto | datum | float32 | float64 | json | msgpack | text\n")
 			fmt.Printf("\n")
 			fmt.Printf("  READ/WRITE\n")
 			fmt.Printf("    GET <key> [from <namespace>.<set>]\n")
-			fmt.Printf("    LOAD <field> [as <variable>]\n")
-			fmt.Printf("    WRITE <variable>[,variable] to <filename>\n")
-			fmt.Printf("    PUT <keys>,<values> [into <namespace>.<set>]\n")
+			fmt.Printf("    LOAD keys | floats | labels [as <variable>]\n")
+			fmt.Printf("    SAMPLE <n> [as <variable>]\n")
+			fmt.Printf("    FOLLOW [n] [as <variable>]\n")
+			fmt.Printf("    WRITE <variable>[,variable] to <filename>[.gz | .zst]\n")
+			fmt.Printf("    PUT <key> <value> | <keys>,<values> [into <namespace>.<set>]\n")
+			fmt.Printf("    DELETE <key> | <keys>\n")
+			fmt.Printf("    COPY <id> to <type>:<path> [from <key>] [until <key>] [keys s/pattern/replacement/] [values /regex/] [resume] [datum]\n")
+			fmt.Printf("    MERGE to <type>:<path> [on-conflict skip | overwrite | rename | fail] [prefix <p1>,<p2>,...]\n")
+			fmt.Printf("    DIFF <id1> <id2>\n")
 			fmt.Printf("\n")
 			fmt.Printf("  IMAGE OPERATIONS\n")
 			fmt.Printf("    GENERATE SIAMESE DATASET <db> with none | cropping[,brightness][,sharpness][,blur]\n")
+			fmt.Printf("    SHUFFLE <id> to <path> [seed <n>] [keys sequential | prefix]\n")
+			fmt.Printf("    SPLIT <id> into train:80,val:10,test:10 [stratify] [seed <n>]\n")
+			fmt.Printf("    DEDUP [exact | ahash | dhash | phash] [threshold <bits>] [as <variable>] [to <type>:<path>]\n")
+			fmt.Printf("    VERIFY [as <variable>]\n")
+			fmt.Printf("    EXPORT <id> images to <folder> [format png | jpg]\n")
 			fmt.Printf("\n")
 			fmt.Printf("  INFO\n")
+			fmt.Printf("    INFO\n")
+			fmt.Printf("    STAT\n")
+			fmt.Printf("    SIZE\n")
 			fmt.Printf("    WHO\n")
 			fmt.Printf("    SHOW namespaces | sets | bins | namespace/<namespace>\n")
 			fmt.Printf("\n")
@@ -674,19 +1292,27 @@ switcher:
 			break
 		}
 
+		parts := strings.Split(strings.Trim(text, " "), " ")
 		f, err := os.Create(parts[3])
 		if err != nil {
 			fmt.Printf("couldn't create file %s\n", parts[3])
 			break
 		}
+		defer f.Close()
+		// .gz and .zst files are compressed
+		w, err := anydb.Compressor(f, anydb.CompressionByExt(parts[3]))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			break
+		}
+		defer w.Close()
 
-		parts := strings.Split(strings.Trim(text, " "), " ")
 		vars := strings.Split(parts[1], ",")
 		v, ok := variables[vars[0]]
 
 		// check if first variable is a Message (only one seems useful right now)
 		if ok && len(vars) == 1 && v.IsMessage() {
-			f.WriteString(v.Message.MarshalText())
+			io.WriteString(w, v.Message.MarshalText())
 			return
 		}
 
@@ -720,23 +1346,27 @@ switcher:
 			first := true
 			for _, v := range vars {
 				if !first {
-					f.WriteString(" ")
+					io.WriteString(w, " ")
 				}
 				matFloat, isfloat := matrixes[v]
 				matChar, ischar := matrixesChar[v]
 				if isfloat {
 					floats := matFloat.RawRowView(i)
 					s := fmt.Sprintf("%v", floats)
-					f.WriteString(s[1 : len(s)-2])
+					io.WriteString(w, s[1:len(s)-2])
 				}
 				if ischar {
-					f.WriteString(fmt.Sprintf("%s", matChar.RowView(i)))
+					io.WriteString(w, fmt.Sprintf("%s", matChar.RowView(i)))
 				}
 				first = false
 			}
-			f.WriteString("\n")
+			io.WriteString(w, "\n")
+		}
+		err = w.Close()
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			break
 		}
-		f.Close()
 		fmt.Printf("%v records written\n", lastr)
 
 	case "db", "dbs", "use":
@@ -749,18 +1379,28 @@ switcher:
 			if parts[1] == "all" {
 				selectedDBs = allDBs
 			} else {
-				ids := strings.Split(parts[1], ",")
+				// parts have been converted to lowercase, reparse it for sub-database names
+				ids := strings.Split(strings.Fields(text)[1], ",")
 				selectedDBs = selectedDBs[:0]
 				for _, id := range ids {
-					i, err := strconv.Atoi(strings.TrimSpace(id))
+					// <id>/<name> selects a named sub-database
+					idName := strings.SplitN(id, "/", 2)
+					i, err := strconv.Atoi(strings.TrimSpace(idName[0]))
 					if err != nil {
 						fmt.Printf("malformed id\n")
 						break
 					}
-					if i > len(allDBs)-1 {
+					if i < 0 || i > len(allDBs)-1 {
 						fmt.Printf("no such id\n")
 						break
 					}
+					if len(idName) == 2 {
+						err = allDBs[i].SetBucket(idName[1])
+						if err != nil {
+							fmt.Printf("%v\n", err)
+							break
+						}
+					}
 					selectedDBs = append(selectedDBs, allDBs[i])
 				}
 			}
@@ -771,7 +1411,11 @@ switcher:
 			} else {
 				fmt.Printf("    ")
 			}
-			fmt.Printf("[%v] %s: %s\n", i, allDBs[i].Identity(), allDBs[i].Path())
+			if name := allDBs[i].Bucket(); name != "" {
+				fmt.Printf("[%v] %s: %s/%s\n", i, allDBs[i].Identity(), allDBs[i].Path(), name)
+			} else {
+				fmt.Printf("[%v] %s: %s\n", i, allDBs[i].Identity(), allDBs[i].Path())
+			}
 		}
 		if debugMode {
 			for i := range selectedDBs {
@@ -833,24 +1477,28 @@ switcher:
 		fmt.Printf(string(b) + "\n")
 
 	case "open":
-		if len(parts) != 2 {
-			fmt.Printf("usage: open path/to/db\n")
+		if len(parts) < 2 {
+			fmt.Printf("usage: open path/to/db [options], see help\n")
 			break
 		}
 		// parts have been converted to lowercase, reparse it before trying to open it
-		parts := strings.Split(strings.Trim(text, " "), " ")
+		parts := strings.Fields(text)
 		dbPath = parts[1]
-		open(parts[1])
+		open(parts[1], anydb.ParseOptions(parts[2:]))
 
 	case "create":
-		if len(parts) != 3 {
-			fmt.Printf("usage: create db <type>:<path>\n")
+		if len(parts) < 3 {
+			fmt.Printf("usage: create db <type>:<path> [options]\n")
 			break
 		}
 		// parts have been converted to lowercase, reparse it before trying to open it
-		parts := strings.Split(strings.Trim(text, " "), " ")
-		newDB := strings.Split(parts[2], ":")
-		_, err := create(newDB[1], newDB[0])
+		parts := strings.Fields(text)
+		newDB := strings.SplitN(parts[2], ":", 2)
+		if len(newDB) != 2 {
+			fmt.Printf("usage: create db <type>:<path> [options]\n")
+			break
+		}
+		_, err := createWith(newDB[1], newDB[0], anydb.ParseOptions(parts[3:]))
 		if err != nil {
 			fmt.Printf("Failed: %v\n", err)
 			return
@@ -1003,6 +1651,79 @@ func newString(s string) (ref *string) {
 	return
 }
 
+// printableKey returns key as a string, or def for a nil key
+func printableKey(key []byte, def string) string {
+	if key == nil {
+		return def
+	}
+	return string(key)
+}
+
+// parseFilterRange reads [i:j], [i:] or [:j]
+func parseFilterRange(s string) (i int, j int, err error) {
+	ij := strings.Split(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"), ":")
+	if len(ij) != 2 {
+		return 0, 0, errors.New("Expected [i:j], [i:], [:j] or /regex/")
+	}
+	if ij[0] != "" {
+		i, err = strconv.Atoi(ij[0])
+	}
+	if err == nil && ij[1] != "" {
+		j, err = strconv.Atoi(ij[1])
+	}
+	if err != nil || i < 0 || j < 0 {
+		return 0, 0, errors.New("Malformed position in filter range")
+	}
+	return
+}
+
+// recordLabel returns the class label of a record, from the db if it knows labels
+// (image folders) or else from the caffe Datum stored in value
+func recordLabel(db *anydb.ADB, key []byte, value []byte) (int32, error) {
+	label, err := db.LabelOf(key)
+	if err == nil {
+		return label, nil
+	}
+	d := &caffe.Datum{}
+	err = proto.Unmarshal(value, d)
+	if err != nil {
+		return 0, err
+	}
+	return d.GetLabel(), nil
+}
+
+// progressTotal formats the number of records a command goes through
+// Estimates are prefixed with ~ and unknown counts shown as ?
+func progressTotal(n uint64, estimated bool) string {
+	switch {
+	case n == 0:
+		return "?"
+	case estimated:
+		return fmt.Sprintf("~%v", n)
+	}
+	return fmt.Sprint(n)
+}
+
+// printInfo shows what is known about a db
+func printInfo(info *anydb.Info) {
+	fmt.Printf("%s (%s)\n", info.Path, info.Identity)
+	records := progressTotal(info.Entries, info.Estimated)
+	if info.Estimated {
+		records += " (estimated)"
+	}
+	fmt.Printf("  %-20s %s\n", "records:", records)
+	if info.DiskSize >= 0 {
+		fmt.Printf("  %-20s %.2f Mb\n", "disk size:", float64(info.DiskSize)/(1024*1024))
+	}
+	if info.Keys.Samples > 0 {
+		fmt.Printf("  %-20s min %v, mean %.1f, max %v (%v samples)\n", "key length:", info.Keys.Min, info.Keys.Mean, info.Keys.Max, info.Keys.Samples)
+		fmt.Printf("  %-20s min %v, mean %.1f, max %v (%v samples)\n", "value size:", info.Values.Min, info.Values.Mean, info.Values.Max, info.Values.Samples)
+	}
+	for _, p := range info.Properties {
+		fmt.Printf("  %-20s %s\n", p.Name+":", p.Value)
+	}
+}
+
 func meanInt(data []uint8) (v float64) {
 	for i := 0; i < len(data); i++ {
 		v += float64(data[i])
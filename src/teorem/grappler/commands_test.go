@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestParseFilterRange(t *testing.T) {
+	tests := []struct {
+		s    string
+		i, j int
+		ok   bool
+	}{
+		{"[2:5]", 2, 5, true},
+		{"[3:]", 3, 0, true},
+		{"[:4]", 0, 4, true},
+		{"[:]", 0, 0, true},
+		{"2:5", 2, 5, true},
+		{"[2]", 0, 0, false},
+		{"[1:2:3]", 0, 0, false},
+		{"[a:2]", 0, 0, false},
+		{"[-1:2]", 0, 0, false},
+		{"[1:-2]", 0, 0, false},
+	}
+	for _, test := range tests {
+		i, j, err := parseFilterRange(test.s)
+		if (err == nil) != test.ok || i != test.i || j != test.j {
+			t.Errorf("%q: got %v, %v, %v", test.s, i, j, err)
+		}
+	}
+}
@@ -3,12 +3,10 @@ package main
 import (
 	"fmt"
 	"strconv"
-	"sync"
+	"sync/atomic"
 	"teorem/anydb"
-	"teorem/grappler/caffe"
 	"time"
 
-	"github.com/golang/protobuf/proto"
 	"github.com/gonum/floats"
 	"github.com/gonum/matrix/mat64"
 )
@@ -18,20 +16,21 @@ func computeImageMean(db *anydb.ADB) {
 	grLog(fmt.Sprintf("computeImagemean %v:%v", db.Identity(), db.Path()))
 
 	start := time.Now()
+	max := progressTotal(db.EstimateEntries())
 
-	type imageJob struct {
-		data []byte
+	// read first image to get channel info, without moving the iterator of db
+	first, err := db.NewCursor()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
 	}
-	images := make(chan imageJob, 100)
-	max := db.Entries()
-
-	db.Scan()
-	db.Reset()
-
-	// read first image to get channel info
-	j := db.Value()
-	d := &caffe.Datum{}
-	err := proto.Unmarshal(j, d)
+	if !first.Valid() {
+		first.Close()
+		fmt.Printf("No records found\n")
+		return
+	}
+	d, err := anydb.DecodeDatum(first.Value())
+	first.Close()
 	if err != nil {
 		fmt.Printf("Did not find a valid caffe.Datum in database\n")
 		return
@@ -40,87 +39,77 @@ func computeImageMean(db *anydb.ADB) {
 	width := int(d.GetWidth())
 	height := int(d.GetHeight())
 	size := width * height
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
 
-	// LOADER
-	go func() {
-		grLog("Image loader started")
-		var c int
-		for {
-			var j imageJob
-			j.data = db.Value()
-			images <- j
-			c++
-			if c%10 == 0 {
-				fmt.Printf("\r[%v:%v] (images: %v) Working...", c, max, len(images))
-			}
-			if !db.Next() || InterruptRequested {
-				break
-			}
-		}
-		if c%10 != 0 {
-			fmt.Printf("\r[%v:%v] (images: %v) Working...", c, max, len(images))
-		}
-		close(images)
-	}()
-
-	// every worker writes to her own channels sums and counters
-	matrixSums := make([]*mat64.Dense, config.Workers*channels)
+	// every shard of the db is read by its own worker, which writes to her own
+	// channels sums and counters
+	matrixSums := make([]*mat64.Dense, workers*channels)
 	for i := range matrixSums {
 		matrixSums[i] = mat64.NewDense(height, width, nil)
 	}
 
-	wCount := make([]int, config.Workers)
-	var wg sync.WaitGroup
-	for w := 0; w < config.Workers; w++ {
-		wg.Add(1)
-		grLog("Worker started")
-		go func(w int) {
-			for {
-				j, more := <-images
-				if (!more) || InterruptRequested {
-					wg.Done()
-					return
-				}
-				d := &caffe.Datum{}
-				err := proto.Unmarshal(j.data, d)
-				if err != nil {
-					fmt.Printf("\nUnmarshaling error: %v\n", err)
-					return
-				}
-				data := d.GetData()
-				floatData := d.GetFloatData()
-				var convData []float64
-				if len(data) == 0 && len(floatData) > 0 {
-					convData = make([]float64, len(floatData))
-					for j := range floatData {
-						convData[j] = float64(floatData[j])
-					}
-				} else {
-					convData = make([]float64, len(data))
-					for j := range data {
-						convData[j] = float64(data[j])
-					}
-				}
-
-				for i := 0; i < channels; i++ {
-					b := mat64.NewDense(height, width, convData[size*i:size*(i+1)])
-					matrixSums[w*channels+i].Add(matrixSums[w*channels+i], b)
-					//meanSums[w*channels+i] += meanInt(data[(size)*i : (size)*(i+1)])
-				}
-				wCount[w]++
+	wCount := make([]int, workers)
+	var c int64
+	var skipped int32
+	err = db.ParallelScan(workers, func(w int, cursor *anydb.Cursor) error {
+		if InterruptRequested {
+			return anydb.ErrStopScan
+		}
+		if n := atomic.AddInt64(&c, 1); n%10 == 0 {
+			fmt.Printf("\r[%v:%v] Working...", n, max)
+		}
+		d, err := anydb.DecodeDatum(cursor.Value())
+		if err != nil {
+			fmt.Printf("\nUnmarshaling error: %v\n", err)
+			return nil
+		}
+		data := d.GetData()
+		floatData := d.GetFloatData()
+		var convData []float64
+		if len(data) == 0 && len(floatData) > 0 {
+			convData = make([]float64, len(floatData))
+			for j := range floatData {
+				convData[j] = float64(floatData[j])
+			}
+		} else {
+			convData = make([]float64, len(data))
+			for j := range data {
+				convData[j] = float64(data[j])
 			}
-		}(w)
+		}
+		// encoded images can differ in size, they don't fit in the mean
+		if len(convData) != channels*size {
+			atomic.AddInt32(&skipped, 1)
+			return nil
+		}
+
+		for i := 0; i < channels; i++ {
+			b := mat64.NewDense(height, width, convData[size*i:size*(i+1)])
+			matrixSums[w*channels+i].Add(matrixSums[w*channels+i], b)
+		}
+		wCount[w]++
+		return nil
+	})
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
 	}
-	wg.Wait()
+	fmt.Printf("\r[%v:%v] Working...", c, max)
 
 	stop := time.Since(start)
 	fmt.Printf("\nDone in %v\n", stop)
+	if skipped > 0 {
+		fmt.Printf("%v images skipped, not %vx%vx%v\n", skipped, channels, height, width)
+	}
 
 	// sum up the workers matrixes
 	finalSums := make([]*mat64.Dense, channels)
 	for i := range finalSums {
 		finalSums[i] = mat64.NewDense(height, width, nil)
-		for w := 0; w < config.Workers; w++ {
+		for w := 0; w < workers; w++ {
 			finalSums[i].Add(finalSums[i], matrixSums[w*channels+i])
 		}
 	}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"teorem/anydb"
+	"teorem/grappler/caffe"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// copyOptions select and transform the records of a copy
+type copyOptions struct {
+	// key range, nil keeps the range set on the source
+	start []byte
+	end   []byte
+	// keys are rewritten with keyPattern.ReplaceAll(key, keyReplacement)
+	keyPattern     *regexp.Regexp
+	keyReplacement []byte
+	// only values matching valueFilter are copied
+	valueFilter *regexp.Regexp
+	// continue after the last key of the destination
+	resume bool
+	// wrap values in encoded caffe Datums labelled by the source
+	datum bool
+}
+
+// parseCopyOptions reads [from <key>] [until <key>] [keys s/pattern/replacement/] [values /regex/] [resume] [datum]
+func parseCopyOptions(args []string) (o copyOptions, err error) {
+	for i := 0; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		if option == "resume" {
+			o.resume = true
+			continue
+		}
+		if option == "datum" {
+			o.datum = true
+			continue
+		}
+		if i+1 >= len(args) {
+			return o, fmt.Errorf("Missing value for %s", option)
+		}
+		i++
+		switch option {
+		case "from":
+			o.start = []byte(args[i])
+		case "until":
+			o.end = []byte(args[i])
+		case "keys":
+			var replacement string
+			o.keyPattern, replacement, err = parseSubstitution(args[i])
+			o.keyReplacement = []byte(replacement)
+		case "values":
+			o.valueFilter, err = parseRegexp(args[i])
+		default:
+			return o, fmt.Errorf("Unknown option %s", option)
+		}
+		if err != nil {
+			return
+		}
+	}
+	if o.resume && o.keyPattern != nil {
+		err = errors.New("Can't resume a copy with transformed keys")
+	}
+	return
+}
+
+// parseRegexp compiles a /regex/
+func parseRegexp(s string) (*regexp.Regexp, error) {
+	if len(s) < 2 || s[0] != '/' || s[len(s)-1] != '/' {
+		return nil, errors.New("Expected /regex/")
+	}
+	return regexp.Compile(s[1 : len(s)-1])
+}
+
+// parseSubstitution splits s/pattern/replacement/ into a regex and its replacement
+// A slash inside pattern or replacement is written as \/
+func parseSubstitution(s string) (*regexp.Regexp, string, error) {
+	if !strings.HasPrefix(s, "s/") || !strings.HasSuffix(s, "/") || len(s) < 4 {
+		return nil, "", errors.New("Expected s/pattern/replacement/")
+	}
+	var fields []string
+	var field []byte
+	body := s[2:]
+	for i := 0; i < len(body); i++ {
+		switch {
+		case body[i] == '\\' && i+1 < len(body) && body[i+1] == '/':
+			field = append(field, '/')
+			i++
+		case body[i] == '/':
+			fields = append(fields, string(field))
+			field = nil
+		default:
+			field = append(field, body[i])
+		}
+	}
+	if len(fields) != 2 || len(field) != 0 {
+		return nil, "", errors.New("Expected s/pattern/replacement/")
+	}
+	re, err := regexp.Compile(fields[0])
+	return re, fields[1], err
+}
+
+// lastDBKey returns the last key of db, nil if it is empty
+func lastDBKey(db *anydb.ADB) ([]byte, error) {
+	err := db.Last()
+	if anydb.IsNotSupported(err) {
+		// walk to the end instead
+		err = db.Reset()
+		var key []byte
+		for err == nil && db.Valid() {
+			key = db.Backend().Key()
+			if !db.Next() {
+				break
+			}
+		}
+		return key, err
+	}
+	if err != nil || !db.Valid() {
+		return nil, err
+	}
+	return db.Backend().Key(), nil
+}
+
+// labelledDatum wraps an encoded image in a caffe Datum with the label of its record
+func labelledDatum(src *anydb.ADB, key []byte, value []byte) ([]byte, error) {
+	label, err := recordLabel(src, key, value)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(&caffe.Datum{
+		Data:    value,
+		Encoded: proto.Bool(true),
+		Label:   proto.Int32(label),
+	})
+}
+
+// copyDB writes the records of src to a new or existing db of the given type
+func copyDB(src *anydb.ADB, destType string, destPath string, o copyOptions) {
+	start := time.Now()
+
+	dest, err := anydb.Create(destPath, destType)
+	if err != nil {
+		fmt.Printf("Could not create db: %v\n", err)
+		return
+	}
+	defer dest.Close()
+
+	// bound the source, restoring its own range afterwards
+	oldStart, oldEnd := src.Range()
+	defer src.SetRange(oldStart, oldEnd)
+	rangeStart, rangeEnd := oldStart, oldEnd
+	if o.start != nil {
+		rangeStart = o.start
+	}
+	if o.end != nil {
+		rangeEnd = o.end
+	}
+	err = src.SetRange(rangeStart, rangeEnd)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	if o.resume {
+		dest.Scan()
+		var last []byte
+		last, err = lastDBKey(dest)
+		if err != nil {
+			fmt.Printf("Could not find where to resume: %v\n", err)
+			return
+		}
+		if last != nil {
+			fmt.Printf("Resuming after %s\n", last)
+			err = src.Seek(last)
+			if err == nil && src.Valid() && bytes.Equal(src.Backend().Key(), last) {
+				src.Next()
+			}
+		} else {
+			err = src.Reset()
+		}
+	} else {
+		err = src.Reset()
+	}
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	total := progressTotal(src.EstimateEntries())
+	writer := dest.NewWriter(0, 0)
+	var count, copied, skipped, size uint64
+	for src.Valid() && !InterruptRequested {
+		key := src.Backend().Key()
+		value := src.Value()
+		count++
+		if o.valueFilter != nil && !o.valueFilter.Match(value) {
+			skipped++
+		} else {
+			err = nil
+			if o.datum {
+				value, err = labelledDatum(src, key, value)
+			}
+			if o.keyPattern != nil {
+				key = o.keyPattern.ReplaceAll(key, o.keyReplacement)
+			}
+			if err == nil {
+				err = writer.Put(key, value)
+			}
+			if err != nil {
+				fmt.Printf("\n%v\n", err)
+			} else {
+				copied++
+				size += uint64(len(key) + len(value))
+			}
+		}
+		if count%10 == 0 {
+			elapsed := time.Since(start).Seconds()
+			fmt.Printf("\r[%v:%v] Copying records... %.0f records/s, %.2f Mb/s", count, total, float64(copied)/elapsed, float64(size)/(1024*1024)/elapsed)
+		}
+		if !src.Next() {
+			break
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+	}
+
+	stop := time.Since(start)
+	fmt.Printf("\r[%v:%v] Copying records... Done in %.4v\n", count, total, stop)
+	fmt.Printf("%v records written in %v commits, %v lost, %v skipped (%.0f records/s, %.2f Mb/s)\n",
+		writer.Written(), writer.Commits(), writer.Failed(), skipped, float64(copied)/stop.Seconds(), float64(size)/(1024*1024)/stop.Seconds())
+	if InterruptRequested {
+		fmt.Printf("Interrupted, add \"resume\" to the same copy command to continue\n")
+	}
+}
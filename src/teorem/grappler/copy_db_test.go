@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestParseSubstitution(t *testing.T) {
+	tests := []struct {
+		s           string
+		pattern     string
+		replacement string
+		ok          bool
+	}{
+		{"s/a/b/", "a", "b", true},
+		{`s/^id_(\d+)$/$1/`, `^id_(\d+)$`, "$1", true},
+		{"s/a//", "a", "", true},
+		{"s//b/", "", "b", true},
+		// escaped slashes
+		{`s/\/x/y\/z/`, "/x", "y/z", true},
+		{`s/a\//b/`, "a/", "b", true},
+		{"s/a/b", "", "", false},
+		{"s/a/b/c/", "", "", false},
+		{"x/a/b/", "", "", false},
+		{"s//", "", "", false},
+		{"s/(/b/", "", "", false},
+	}
+	for _, test := range tests {
+		re, replacement, err := parseSubstitution(test.s)
+		if (err == nil) != test.ok {
+			t.Errorf("%q: error %v", test.s, err)
+			continue
+		}
+		if test.ok && (re.String() != test.pattern || replacement != test.replacement) {
+			t.Errorf("%q: got %q and %q", test.s, re, replacement)
+		}
+	}
+}
@@ -2,17 +2,14 @@ package main
 
 import (
 	"fmt"
-	"math"
 	"net/http"
 	"strings"
 	"teorem/anydb"
-	"teorem/grappler/caffe"
 
 	"image/color"
 	"image/jpeg"
 
 	"github.com/disintegration/imaging"
-	"github.com/golang/protobuf/proto"
 )
 
 var browseKeys []string
@@ -26,20 +23,27 @@ func launchBrowser(db *anydb.ADB) {
 	http.HandleFunc("/image/", dbImage)
 	go http.ListenAndServe(":5001", nil)
 
-	max := int(math.Min(500, float64(db.Entries())))
-	browseKeys = make([]string, max)
-	i := 0
-	for {
-		browseKeys[i] = string(db.Key())
-		if !db.Next() {
-			break
-		}
-		i++
-		if i >= max {
+	// list keys from the current record on with a cursor of its own, so the
+	// iterator used by ls and next stays where it is
+	cursor, err := db.NewCursor()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	defer cursor.Close()
+	if db.Valid() {
+		cursor.Seek(db.Backend().Key())
+	}
+
+	max := 500
+	browseKeys = make([]string, 0, max)
+	for cursor.Valid() && len(browseKeys) < max {
+		browseKeys = append(browseKeys, string(cursor.Key()))
+		if !cursor.Next() {
 			break
 		}
 	}
-	fmt.Printf("%v keys loaded\n", i+1)
+	fmt.Printf("%v keys loaded\n", len(browseKeys))
 }
 
 func dbBrowser(res http.ResponseWriter, req *http.Request) {
@@ -62,28 +66,9 @@ func dbImage(res http.ResponseWriter, req *http.Request) {
 	uris := strings.Split(req.RequestURI, "/")
 	key := uris[len(uris)-1]
 	_, value, _ := browseDB.Get([]byte(key))
-	datum := new(caffe.Datum)
-	proto.Unmarshal(value, datum)
-
-	img := imaging.New(int(datum.GetWidth()*datum.GetChannels()/3), int(datum.GetHeight()), color.White)
-
-	if datum.GetChannels() == 6 {
-		// image 1
-		w := int(datum.GetWidth())
-		h := int(datum.GetHeight())
-		s := w * h
-		for y := 0; y < h; y++ {
-			for x := 0; x < w; x++ {
-				img.Set(int(x), int(y), color.NRGBA{A: 255, R: uint8(datum.Data[0*s+y*w+x]), G: uint8(datum.Data[1*s+y*w+x]), B: uint8(datum.Data[2*s+y*w+x])})
-			}
-		}
-
-		// image 2
-		for y := 0; y < h; y++ {
-			for x := 0; x < w; x++ {
-				img.Set(x+w, int(y), color.NRGBA{A: 255, R: uint8(datum.Data[3*s+y*w+x]), G: uint8(datum.Data[4*s+y*w+x]), B: uint8(datum.Data[5*s+y*w+x])})
-			}
-		}
+	img, err := anydb.ValueImage(value, browseDB.RGB())
+	if err != nil {
+		img = imaging.New(1, 1, color.White)
 	}
 
 	jpeg.Encode(res, img, nil)
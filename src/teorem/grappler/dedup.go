@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"image"
+	"sort"
+	"strings"
+	"sync"
+	"teorem/anydb"
+	"teorem/multimatrix/matchar"
+	"time"
+)
+
+// perceptual hashes available to dedup
+var imageHashes = map[string]func(image.Image) uint64{
+	"ahash": aHash,
+	"dhash": dHash,
+	"phash": pHash,
+}
+
+// dedupOptions select how duplicates are found and what is done with them
+type dedupOptions struct {
+	// method is exact or one of imageHashes
+	method string
+	// maximum number of differing hash bits for near duplicates, 0 to 63
+	threshold int
+	// variable receiving the groups
+	variable string
+	// deduplicated copy, empty for none
+	destType string
+	destPath string
+}
+
+// unionFind groups records, every group is represented by its lowest index
+type unionFind []int
+
+func (u unionFind) find(i int) int {
+	for u[i] != i {
+		u[i] = u[u[i]]
+		i = u[i]
+	}
+	return i
+}
+
+func (u unionFind) union(a int, b int) {
+	a, b = u.find(a), u.find(b)
+	if a < b {
+		u[b] = a
+	} else if b < a {
+		u[a] = b
+	}
+}
+
+// dedupDB finds records with identical values, and with an image hash method records
+// whose decoded images are within threshold bits of each other
+// Every group of duplicates becomes a row of space separated keys in a char matrix
+func dedupDB(db *anydb.ADB, o dedupOptions) {
+	start := time.Now()
+	max := progressTotal(db.EstimateEntries())
+	imageHash := imageHashes[o.method]
+
+	db.Scan()
+	db.Reset()
+	if !db.Valid() {
+		fmt.Printf("No records found\n")
+		return
+	}
+
+	type dedupJob struct {
+		i     int
+		value []byte
+	}
+	type dedupResult struct {
+		i    int
+		sum  [sha1.Size]byte
+		hash uint64
+		err  error
+	}
+	jobs := make(chan dedupJob, 100)
+	results := make(chan dedupResult, 100)
+
+	// LOADER
+	var keys []string
+	go func() {
+		for db.Valid() && !InterruptRequested {
+			keys = append(keys, string(db.Key()))
+			// copy, some backends reuse the value buffer when moving on
+			jobs <- dedupJob{len(keys) - 1, append([]byte(nil), db.Value()...)}
+			if !db.Next() {
+				break
+			}
+		}
+		close(jobs)
+	}()
+
+	// WORKERS hash the raw value and decode the image if needed
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				r := dedupResult{i: j.i, sum: sha1.Sum(j.value)}
+				if imageHash != nil {
+					var img image.Image
+					img, r.err = decodeImage(j.value)
+					if r.err == nil {
+						r.hash = imageHash(img)
+					}
+				}
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var sums [][sha1.Size]byte
+	var hashes []uint64
+	var decoded []bool
+	var c, failed int
+	for r := range results {
+		for len(sums) <= r.i {
+			sums = append(sums, [sha1.Size]byte{})
+			hashes = append(hashes, 0)
+			decoded = append(decoded, false)
+		}
+		sums[r.i], hashes[r.i], decoded[r.i] = r.sum, r.hash, r.err == nil
+		if r.err != nil {
+			failed++
+		}
+		c++
+		if c%10 == 0 {
+			fmt.Printf("\r[%v:%v] Hashing...", c, max)
+		}
+	}
+	fmt.Printf("\r[%v:%v] Hashing... Done in %v\n", c, max, time.Since(start))
+	if InterruptRequested {
+		return
+	}
+	if imageHash != nil && failed > 0 {
+		fmt.Printf("%v records could not be decoded as images, only exact duplicates are found for them\n", failed)
+	}
+
+	n := len(sums)
+	groups := make(unionFind, n)
+	for i := range groups {
+		groups[i] = i
+	}
+	exact := make(map[[sha1.Size]byte]int)
+	for i, s := range sums {
+		if j, ok := exact[s]; ok {
+			groups.union(i, j)
+		} else {
+			exact[s] = i
+		}
+	}
+	if imageHash != nil {
+		nearDuplicates(hashes, decoded, o.threshold, groups)
+	}
+
+	// collect groups of more than one record, in key order
+	members := make(map[int][]int)
+	for i := 0; i < n; i++ {
+		root := groups.find(i)
+		members[root] = append(members[root], i)
+	}
+	roots := make([]int, 0)
+	for root, m := range members {
+		if len(m) > 1 {
+			roots = append(roots, root)
+		}
+	}
+	sort.Ints(roots)
+	result := matchar.NewMatchar(nil)
+	drop := make([]bool, n)
+	duplicates := 0
+	for _, root := range roots {
+		m := members[root]
+		row := make([]string, len(m))
+		for k, i := range m {
+			row[k] = keys[i]
+			if k > 0 {
+				drop[i] = true
+				duplicates++
+			}
+		}
+		result.Append(strings.Join(row, " "))
+	}
+	matrixesChar[o.variable] = result
+	fmt.Printf("%v duplicates in %v groups, stored in %v\n", duplicates, len(roots), o.variable)
+
+	if o.destPath != "" {
+		writeDeduplicated(db, drop, o.destType, o.destPath)
+	}
+}
+
+// nearDuplicates joins the groups of records whose hashes differ by at most threshold bits
+// Hashes are cut in threshold+1 blocks, two hashes within the threshold share at least one
+// block, so only records sharing a block value are compared. threshold is 0 to 63
+func nearDuplicates(hashes []uint64, decoded []bool, threshold int, groups unionFind) {
+	blocks := threshold + 1
+	width := 64 / blocks
+	for b := 0; b < blocks; b++ {
+		shift := uint(b * width)
+		bits := width
+		if b == blocks-1 {
+			bits = 64 - b*width
+		}
+		mask := uint64(1)<<uint(bits) - 1
+		if bits == 64 {
+			mask = ^uint64(0)
+		}
+		buckets := make(map[uint64][]int)
+		for i, h := range hashes {
+			if decoded[i] {
+				v := h >> shift & mask
+				buckets[v] = append(buckets[v], i)
+			}
+		}
+		for _, bucket := range buckets {
+			for x := range bucket {
+				for y := x + 1; y < len(bucket); y++ {
+					if hammingDistance(hashes[bucket[x]], hashes[bucket[y]]) <= threshold {
+						groups.union(bucket[x], bucket[y])
+					}
+				}
+			}
+		}
+	}
+}
+
+// writeDeduplicated copies all records of db not marked in drop, in key order
+func writeDeduplicated(db *anydb.ADB, drop []bool, destType string, destPath string) {
+	dest, err := anydb.Create(destPath, destType)
+	if err != nil {
+		fmt.Printf("Could not create db: %v\n", err)
+		return
+	}
+	defer dest.Close()
+
+	writer := dest.NewWriter(0, 0)
+	i := 0
+	db.Reset()
+	for db.Valid() && i < len(drop) && !InterruptRequested {
+		if !drop[i] {
+			err = writer.Put(db.Backend().Key(), db.Value())
+			if err != nil {
+				fmt.Printf("\n%v\n", err)
+			}
+		}
+		i++
+		if i%10 == 0 {
+			fmt.Printf("\r[%v:%v] Writing records...", i, len(drop))
+		}
+		if !db.Next() {
+			break
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+	}
+	fmt.Printf("\r[%v:%v] Writing records... Done\n", i, len(drop))
+	fmt.Printf("%v records written in %v commits, %v lost\n", writer.Written(), writer.Commits(), writer.Failed())
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNearDuplicates(t *testing.T) {
+	tests := []struct {
+		hashes    []uint64
+		decoded   []bool
+		threshold int
+		// the root of the group of every record
+		want []int
+	}{
+		{[]uint64{0, 0, 1}, nil, 0, []int{0, 0, 2}},
+		{[]uint64{0, 1, 3, 7}, nil, 1, []int{0, 0, 0, 0}},
+		{[]uint64{0, 3, 0xf0}, nil, 1, []int{0, 1, 2}},
+		{[]uint64{0, 3, 0xf0}, nil, 2, []int{0, 0, 2}},
+		// bits spread over all blocks
+		{[]uint64{0, 0x8000000100000001, 0x8000000100000003}, nil, 3, []int{0, 0, 0}},
+		{[]uint64{0, 0x8000000100000001, 0x8000000100000003}, nil, 2, []int{0, 1, 1}},
+		// records not decoded are left alone
+		{[]uint64{0, 0, 0}, []bool{true, false, true}, 5, []int{0, 1, 0}},
+		{[]uint64{0, ^uint64(0) >> 1, 1 << 63}, nil, 63, []int{0, 0, 0}},
+		{[]uint64{0, ^uint64(0)}, nil, 63, []int{0, 1}},
+	}
+	for _, test := range tests {
+		n := len(test.hashes)
+		decoded := test.decoded
+		if decoded == nil {
+			decoded = make([]bool, n)
+			for i := range decoded {
+				decoded[i] = true
+			}
+		}
+		groups := make(unionFind, n)
+		for i := range groups {
+			groups[i] = i
+		}
+		nearDuplicates(test.hashes, decoded, test.threshold, groups)
+		roots := make([]int, n)
+		for i := range roots {
+			roots[i] = groups.find(i)
+		}
+		if !reflect.DeepEqual(roots, test.want) {
+			t.Errorf("%x within %v bits: got %v, want %v", test.hashes, test.threshold, roots, test.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+	"teorem/anydb"
+	"teorem/grappler/caffe"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// diffMaxShown is the number of differences printed of each kind
+const diffMaxShown = 50
+
+// diffDB walks both dbs in key order and reports keys found in only one of them
+// and keys with different values
+func diffDB(a *anydb.ADB, b *anydb.ADB) {
+	start := time.Now()
+	max := progressTotal(a.EstimateEntries())
+
+	a.Scan()
+	a.Reset()
+	b.Scan()
+	b.Reset()
+
+	var count, onlyA, onlyB, differ, same int
+	show := func(n int, format string, args ...interface{}) {
+		if n <= diffMaxShown {
+			fmt.Printf("\r"+format+"\n", args...)
+		}
+	}
+	for (a.Valid() || b.Valid()) && !InterruptRequested {
+		c := 0
+		switch {
+		case !b.Valid():
+			c = -1
+		case !a.Valid():
+			c = 1
+		default:
+			c = bytes.Compare(a.Backend().Key(), b.Backend().Key())
+		}
+		switch {
+		case c < 0:
+			onlyA++
+			show(onlyA, "< %s", a.Backend().Key())
+			a.Next()
+		case c > 0:
+			onlyB++
+			show(onlyB, "> %s", b.Backend().Key())
+			b.Next()
+		default:
+			if bytes.Equal(a.Value(), b.Value()) {
+				same++
+			} else {
+				differ++
+				show(differ, "! %s: %s", a.Backend().Key(), diffValues(a.Value(), b.Value()))
+			}
+			a.Next()
+			b.Next()
+		}
+		count++
+		if count%10 == 0 {
+			fmt.Printf("\r[%v:%v] Comparing...", count, max)
+		}
+	}
+	fmt.Printf("\r[%v:%v] Comparing... Done in %v\n", count, max, time.Since(start))
+	if InterruptRequested {
+		fmt.Printf("Interrupted, counts are partial\n")
+	}
+	fmt.Printf("%v identical, %v different, %v only in %v, %v only in %v\n", same, differ, onlyA, a.Path(), onlyB, b.Path())
+	if onlyA > diffMaxShown || onlyB > diffMaxShown || differ > diffMaxShown {
+		fmt.Printf("(only the first %v of each kind are listed)\n", diffMaxShown)
+	}
+}
+
+// diffValues describes how two values differ, field by field for caffe Datums
+func diffValues(a []byte, b []byte) string {
+	da, db := &caffe.Datum{}, &caffe.Datum{}
+	if proto.Unmarshal(a, da) != nil || proto.Unmarshal(b, db) != nil || !isDatum(da) || !isDatum(db) {
+		return fmt.Sprintf("values differ (%v vs %v bytes)", len(a), len(b))
+	}
+
+	var diffs []string
+	if da.GetLabel() != db.GetLabel() {
+		diffs = append(diffs, fmt.Sprintf("label %v vs %v", da.GetLabel(), db.GetLabel()))
+	}
+	if da.GetChannels() != db.GetChannels() || da.GetHeight() != db.GetHeight() || da.GetWidth() != db.GetWidth() {
+		diffs = append(diffs, fmt.Sprintf("dims %vx%vx%v vs %vx%vx%v",
+			da.GetChannels(), da.GetHeight(), da.GetWidth(), db.GetChannels(), db.GetHeight(), db.GetWidth()))
+	}
+	if da.GetEncoded() != db.GetEncoded() {
+		diffs = append(diffs, fmt.Sprintf("encoded %v vs %v", da.GetEncoded(), db.GetEncoded()))
+	}
+	if dataA, dataB := da.GetData(), db.GetData(); len(dataA) != len(dataB) {
+		diffs = append(diffs, fmt.Sprintf("data %v vs %v bytes", len(dataA), len(dataB)))
+	} else if n := countDiffBytes(dataA, dataB); n > 0 {
+		diffs = append(diffs, fmt.Sprintf("%v of %v data bytes", n, len(dataA)))
+	}
+	if fa, fb := da.GetFloatData(), db.GetFloatData(); len(fa) != len(fb) {
+		diffs = append(diffs, fmt.Sprintf("float_data %v vs %v values", len(fa), len(fb)))
+	} else {
+		var maxDiff float64
+		for i := range fa {
+			maxDiff = math.Max(maxDiff, math.Abs(float64(fa[i])-float64(fb[i])))
+		}
+		if maxDiff > 0 {
+			diffs = append(diffs, fmt.Sprintf("float_data max abs diff %g", maxDiff))
+		}
+	}
+	if len(diffs) == 0 {
+		return "same Datum fields, different encoding"
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// isDatum guesses if a successfully unmarshaled Datum really was one
+func isDatum(d *caffe.Datum) bool {
+	return d.Channels != nil || len(d.Data) > 0 || len(d.FloatData) > 0
+}
+
+// countDiffBytes returns the number of positions where a and b differ
+func countDiffBytes(a []byte, b []byte) (n int) {
+	for i := range a {
+		if a[i] != b[i] {
+			n++
+		}
+	}
+	return
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"teorem/anydb"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// exportFileName turns a key into a file name, characters that are not safe in
+// paths are replaced with _
+func exportFileName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// exportImages writes the image of every record of db to folder as <key>.<format>, the
+// two images of a 6 channel Datum as <key>_A and <key>_B. Keys and labels are listed
+// in labels.csv next to them
+func exportImages(db *anydb.ADB, folder string, format string) {
+	start := time.Now()
+	max := progressTotal(db.EstimateEntries())
+
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	db.Scan()
+	db.Reset()
+	if !db.Valid() {
+		fmt.Printf("No records found\n")
+		return
+	}
+
+	type exportJob struct {
+		key   []byte
+		name  string
+		value []byte
+	}
+	type exportResult struct {
+		key     string
+		files   []string
+		label   string
+		problem error
+	}
+	jobs := make(chan exportJob, 100)
+	results := make(chan exportResult, 100)
+
+	// LOADER
+	go func() {
+		for db.Valid() && !InterruptRequested {
+			// copy, some backends reuse the value buffer when moving on
+			jobs <- exportJob{append([]byte(nil), db.Backend().Key()...), string(db.Key()), append([]byte(nil), db.Value()...)}
+			if !db.Next() {
+				break
+			}
+		}
+		close(jobs)
+	}()
+
+	// WORKERS decode and encode the images
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				r := exportResult{key: j.name}
+				var images []image.Image
+				images, r.problem = anydb.ValueImages(j.value, db.RGB())
+				if r.problem == nil {
+					name := exportFileName(j.name)
+					for i, img := range images {
+						file := name + "." + format
+						if len(images) > 1 {
+							file = fmt.Sprintf("%s_%c.%s", name, 'A'+i, format)
+						}
+						r.problem = imaging.Save(img, filepath.Join(folder, file))
+						if r.problem != nil {
+							break
+						}
+						r.files = append(r.files, file)
+					}
+				}
+				if label, err := recordLabel(db, j.key, j.value); err == nil {
+					r.label = fmt.Sprint(label)
+				}
+				results <- r
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	exported := make(map[string]exportResult)
+	var keys []string
+	var c, failed int
+	for r := range results {
+		c++
+		if r.problem != nil {
+			failed++
+			fmt.Printf("\r%s: %v\n", r.key, r.problem)
+		} else {
+			exported[r.key] = r
+			keys = append(keys, r.key)
+		}
+		if c%10 == 0 {
+			fmt.Printf("\r[%v:%v] (failed: %v) Exporting images...", c, max, failed)
+		}
+	}
+	fmt.Printf("\r[%v:%v] (failed: %v) Exporting images... Done in %v\n", c, max, failed, time.Since(start))
+
+	// workers finish in any order, list the files in key order
+	sort.Strings(keys)
+	f, err := os.Create(filepath.Join(folder, "labels.csv"))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write([]string{"file", "key", "label"})
+	for _, k := range keys {
+		r := exported[k]
+		for _, file := range r.files {
+			w.Write([]string{file, r.key, r.label})
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Printf("%v records exported to %v, labels in %v\n", len(keys), folder, filepath.Join(folder, "labels.csv"))
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"teorem/anydb"
+	"time"
+
+	"github.com/gonum/matrix/mat64"
+)
+
+// followInterval is how long follow waits before looking for new records again
+const followInterval = 500 * time.Millisecond
+
+// followDB prints the keys of records appended to db from now on, until n have arrived
+// (0 for no limit) or the user interrupts. If mat is not empty the floats of the
+// records are appended to that matrix, which is created if needed
+func followDB(db *anydb.ADB, n int, mat string) {
+	if db.Reverse() {
+		fmt.Printf("Turn off reverse first\n")
+		return
+	}
+
+	// skip the records already there
+	err := db.Last()
+	if anydb.IsNotSupported(err) {
+		db.Reset()
+		for db.Valid() && db.Next() && !InterruptRequested {
+		}
+	} else if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	var m *mat64.Dense
+	var rows int
+	if mat != "" {
+		if m = matrixes[mat]; m != nil {
+			if r, c := m.Dims(); r == 0 || c == 0 {
+				m = nil
+			} else {
+				rows = r
+			}
+		}
+	}
+
+	fmt.Printf("Following %v:%v, interrupt to stop\n", db.Identity(), db.Path())
+	start := time.Now()
+	count := 0
+	for !InterruptRequested && (n == 0 || count < n) {
+		if !db.Follow() {
+			time.Sleep(followInterval)
+			continue
+		}
+		key := db.Key()
+		value := db.Value()
+		fmt.Printf("%s (%v bytes)\n", key, len(value))
+		count++
+		if mat == "" {
+			continue
+		}
+
+		f, err := db.Floats(value)
+		if err != nil {
+			fmt.Printf("%s: %v\n", key, err)
+			continue
+		}
+		if m == nil {
+			m = mat64.NewDense(1, len(f), nil)
+		} else if r, c := m.Dims(); c != len(f) {
+			fmt.Printf("%s: %v values, %v has %v columns\n", key, len(f), mat, c)
+			continue
+		} else if rows >= r {
+			// double the rows, the ones not used are dropped at the end
+			m = m.Grow(r, 0).(*mat64.Dense)
+		}
+		m.SetRow(rows, f)
+		rows++
+	}
+	fmt.Printf("%v records in %v\n", count, time.Since(start))
+
+	if m != nil {
+		if r, c := m.Dims(); rows < r {
+			m = mat64.DenseCopyOf(m.View(0, 0, rows, c))
+		}
+		matrixes[mat] = m
+		printMatrix(mat)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"sync/atomic"
+	"teorem/anydb"
 	"teorem/grappler/caffe"
 	"time"
 
@@ -14,12 +15,17 @@ import (
 	"github.com/golang/protobuf/proto"
 )
 
+// siameseClassTries is how many random images of the same class are passed over
+// when looking for the second image of a dissimilar pair
+const siameseClassTries = 10
+
 func generateSiameseDataset(dbName string, destW, destH int, todo []string) {
 	var max int
 	if limit != 0 {
 		max = int(limit)
 	} else {
-		max = int(selectedDBs[0].Entries())
+		entries, _ := selectedDBs[0].EstimateEntries()
+		max = int(entries)
 	}
 
 	start := time.Now()
@@ -44,12 +50,18 @@ func generateSiameseDataset(dbName string, destW, destH int, todo []string) {
 	}
 	grLogsf(logFile, "Operations: %v\n", todo)
 	grLogsf(logFile, "Number of operations on each image: %v\n", config.Generate.OperationCount)
+	if classes, err := selectedDBs[0].Classes(); err == nil {
+		grLogsf(logFile, "Dissimilar pairs are taken from different classes, %v classes\n", len(classes))
+	}
 	fmt.Printf("Working...\n")
 
 	type imageJob struct {
 		key   []byte
 		value []byte
 		count int
+		// class of the image, if the db knows labels
+		label   int32
+		labeled bool
 	}
 	randomImages := make(chan imageJob, 100)
 	type jobResult struct {
@@ -103,8 +115,14 @@ func generateSiameseDataset(dbName string, destW, destH int, todo []string) {
 					case 0:
 						// DISSIMILAR PAIR
 						// read another one from randomImages
+						tries := 0
 						for {
 							k := <-randomImages
+							// with labels look for an image of another class, a few times
+							if j.labeled && k.labeled && k.label == j.label && tries < siameseClassTries {
+								tries++
+								continue
+							}
 							dst, err = imaging.Decode(bytes.NewReader(k.value))
 							if err == nil {
 								// if we happenened the get the same image, change the label to similar
@@ -249,6 +267,8 @@ func generateSiameseDataset(dbName string, destW, destH int, todo []string) {
 					var j imageJob
 					j.key, j.value, err = selectedDBs[0].GetRandom()
 					if err == nil {
+						label, labelErr := selectedDBs[0].LabelOf(j.key)
+						j.label, j.labeled = label, labelErr == nil
 						j.count = count
 						randomImages <- j
 						count++
@@ -258,13 +278,36 @@ func generateSiameseDataset(dbName string, destW, destH int, todo []string) {
 		}
 	}()
 
-	var wCount, writeFailures int
-	var meanSums [3]float64
 	grLog("DB writer started")
+	writer := newDB.NewWriter(0, 0)
+	// records only count, and go into the means, once they are committed
+	var n, wCount, writeFailures int
+	var meanSums [3]float64
+	// channel sums of the records buffered in writer
+	var pending [][3]float64
+	commit := func(lost int) {
+		for _, p := range pending[:len(pending)-lost] {
+			for i := range meanSums {
+				meanSums[i] += p[i]
+			}
+		}
+		pending = pending[:0]
+		wCount = int(writer.Written())
+	}
 	for r := range results {
 		// create unique key
-		k := fmt.Sprintf("%010d", wCount)
-		err = newDB.Put([]byte(k), r.value)
+		k := fmt.Sprintf("%010d", n)
+		n++
+		var sums [3]float64
+		for i := 0; i < 3; i++ {
+			sums[i] += r.meanValues[i]   //add first image (channel 0,1,2)
+			sums[i] += r.meanValues[i+3] //add second image (channel 3,4,5)
+		}
+		pending = append(pending, sums)
+		err = writer.Put([]byte(k), r.value)
+		if ce, ok := err.(*anydb.CommitError); ok {
+			commit(ce.Records)
+		}
 		if err != nil {
 			fmt.Printf("\nCouldn't save to db: %v\n", err)
 			writeFailures++
@@ -274,16 +317,24 @@ func generateSiameseDataset(dbName string, destW, destH int, todo []string) {
 			}
 			continue
 		}
-		wCount++
-		for i := 0; i < 3; i++ {
-			meanSums[i] += r.meanValues[i]   //add first image (channel 0,1,2)
-			meanSums[i] += r.meanValues[i+3] //add second image (channel 3,4,5)
+		if writer.Pending() == 0 {
+			commit(0)
 		}
-		fmt.Printf("\r[%v:%v] (images: %v, results: %v) %s (%v bytes)", wCount, max, len(randomImages), len(results), k, len(r.value))
-		if wCount >= max || InterruptRequested {
+		done := wCount + writer.Pending()
+		fmt.Printf("\r[%v:%v] (images: %v, results: %v) %s (%v bytes)", done, max, len(randomImages), len(results), k, len(r.value))
+		if done >= max || InterruptRequested {
 			break
 		}
 	}
+	err = writer.Flush()
+	if ce, ok := err.(*anydb.CommitError); ok {
+		commit(ce.Records)
+	} else if err == nil {
+		commit(0)
+	}
+	if err != nil {
+		fmt.Printf("\nCouldn't save to db: %v\n", err)
+	}
 	grLog("\nDB writer finished")
 	grCloseDB(newDB)
 
@@ -302,6 +353,7 @@ func generateSiameseDataset(dbName string, destW, destH int, todo []string) {
 
 	stop := time.Since(start)
 	fmt.Printf("\nDone in %.4v\n", stop)
+	grLogsf(logFile, "%v records written in %v commits, %v lost\n", writer.Written(), writer.Commits(), writer.Failed())
 
 	grLogsf(logFile, "Operation stats:\ncrops: %v\nblur: %v\nsharpness: %v\ncontrast: %v\ngamma: %v\nbrightness: %v\nnoop: %v\n", cr, bl, sh, co, ga, br, no)
 
@@ -103,7 +103,7 @@ func main() {
 	if len(os.Args) > 1 {
 		for i := 1; i < len(os.Args); i++ {
 			dbPath = os.Args[i]
-			open(dbPath)
+			open(dbPath, nil)
 		}
 	}
 	usr, _ = user.Current()
@@ -184,7 +184,12 @@ func grCloseDB(db *anydb.ADB) {
 // Create new db and return it
 // Does not update selectedDBs
 func create(path string, dbtype string) (db *anydb.ADB, err error) {
-	db, err = anydb.Create(path, dbtype)
+	return createWith(path, dbtype, nil)
+}
+
+// createWith is create with backend options
+func createWith(path string, dbtype string, o anydb.Options) (db *anydb.ADB, err error) {
+	db, err = anydb.CreateWith(path, dbtype, o)
 	if err != nil {
 		return nil, err
 	}
@@ -197,7 +202,8 @@ func create(path string, dbtype string) (db *anydb.ADB, err error) {
 // Open LMDB, leveldb, aerospike server or just an file folder
 // Will try to guess which kinds of database path refers to
 // We can also give directions with "aerospike:t4", "lmdb:/foo/bar"
-func open(path string) {
+// Options are passed on to the backend, like readonly for lmdb
+func open(path string, o anydb.Options) {
 
 	dbType := ""
 
@@ -259,17 +265,22 @@ func open(path string) {
 			return
 		}
 
-		myDB, err := anydb.Open(p, dbType)
+		myDB, err := anydb.OpenWith(p, dbType, o)
 		if err == nil {
 			fmt.Printf("Database opened.")
-			e := myDB.Entries()
-			if e > 0 {
+			e, estimated := myDB.EstimateEntries()
+			if e > 0 && estimated {
+				fmt.Printf(" About %v records found", e)
+			} else if e > 0 {
 				fmt.Printf(" %v records found", e)
 			}
 			fmt.Printf("\n")
 
 			// setup iterator
-			myDB.Scan()
+			err = myDB.Scan()
+			if err != nil {
+				grLogs("Scan failed: %v", err)
+			}
 
 			allDBs = append(allDBs, myDB)
 			selectedDBs = []*anydb.ADB{myDB}
@@ -313,18 +324,43 @@ var completer = readline.NewPrefixCompleter(
 	readline.PcItem("use"),
 	readline.PcItem("reset"),
 	readline.PcItem("help", readline.PcItemDynamic(listFunctions)),
-	readline.PcItem("ls"),
+	readline.PcItem("ls", readline.PcItem("-n")),
+	readline.PcItem("next"),
+	readline.PcItem("prev"),
+	readline.PcItem("seek"),
+	readline.PcItem("start"),
+	readline.PcItem("end"),
+	readline.PcItem("prefix"),
+	readline.PcItem("range", readline.PcItem("off")),
 	readline.PcItem("open",
-		readline.PcItemDynamic(listFiles(".")),
+		readline.PcItemDynamic(listFiles("."),
+			readline.PcItem("readonly"), readline.PcItem("nolock"), readline.PcItem("nogrow"), readline.PcItem("mapsize="), readline.PcItem("db="),
+			readline.PcItem("delimiter="), readline.PcItem("header"), readline.PcItem("noheader"), readline.PcItem("key="), readline.PcItem("values=")),
 	),
 	readline.PcItem("who"),
+	readline.PcItem("info"),
+	readline.PcItem("stat"),
+	readline.PcItem("size"),
 	readline.PcItem("show", readline.PcItem("namespaces"), readline.PcItem("sets"), readline.PcItem("bins")),
 	readline.PcItem("generate", readline.PcItem("siamese", readline.PcItem("dataset"))),
 	readline.PcItem("get"),
-	readline.PcItem("load", readline.PcItem("keys"), readline.PcItem("floats")),
-	readline.PcItem("set", readline.PcItem("limit"), readline.PcItem("filter")),
+	readline.PcItem("load", readline.PcItem("keys"), readline.PcItem("floats"), readline.PcItem("labels")),
+	readline.PcItem("sample"),
+	readline.PcItem("follow"),
+	readline.PcItem("set", readline.PcItem("limit"), readline.PcItem("filter"), readline.PcItem("keymap"), readline.PcItem("bucket"), readline.PcItem("reverse"), readline.PcItem("channels", readline.PcItem("rgb"), readline.PcItem("bgr")),
+		readline.PcItem("codec", readline.PcItem("auto"), readline.PcItem("datum"), readline.PcItem("float32"), readline.PcItem("float64"),
+			readline.PcItem("json"), readline.PcItem("msgpack"), readline.PcItem("text"))),
 	readline.PcItem("write", readline.PcItemDynamic(listVars)),
 	readline.PcItem("put"),
+	readline.PcItem("delete"),
+	readline.PcItem("copy"),
+	readline.PcItem("shuffle"),
+	readline.PcItem("split"),
+	readline.PcItem("verify"),
+	readline.PcItem("export"),
+	readline.PcItem("dedup", readline.PcItem("exact"), readline.PcItem("ahash"), readline.PcItem("dhash"), readline.PcItem("phash")),
+	readline.PcItem("diff"),
+	readline.PcItem("merge", readline.PcItem("to")),
 	readline.PcItemDynamic(listVars, readline.PcItem("=", readline.PcItemDynamic(listVars))),
 )
 
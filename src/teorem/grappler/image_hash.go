@@ -0,0 +1,110 @@
+package main
+
+import (
+	"image"
+	"math"
+	"sort"
+	"teorem/anydb"
+
+	"github.com/disintegration/imaging"
+)
+
+// decodeImage returns the image stored in a value, either an encoded file (folders)
+// or a caffe Datum with encoded or raw BGR data. Only the first image of a 6 channel
+// siamese Datum is returned
+func decodeImage(value []byte) (image.Image, error) {
+	images, err := anydb.ValueImages(value, false)
+	if err != nil {
+		return nil, err
+	}
+	return images[0], nil
+}
+
+// grayPixels resizes img to w x h and returns its gray values row by row
+func grayPixels(img image.Image, w int, h int) []float64 {
+	small := imaging.Grayscale(imaging.Resize(img, w, h, imaging.Box))
+	p := make([]float64, w*h)
+	for i := range p {
+		p[i] = float64(small.Pix[i*4])
+	}
+	return p
+}
+
+// aHash sets a bit for every pixel of an 8x8 thumbnail brighter than the mean
+func aHash(img image.Image) (hash uint64) {
+	p := grayPixels(img, 8, 8)
+	var mean float64
+	for _, v := range p {
+		mean += v / 64
+	}
+	for i, v := range p {
+		if v > mean {
+			hash |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+// dHash sets a bit for every pixel of a 9x8 thumbnail darker than its right neighbour
+func dHash(img image.Image) (hash uint64) {
+	p := grayPixels(img, 9, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if p[y*9+x] < p[y*9+x+1] {
+				hash |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return
+}
+
+// dctTable holds cos((2x+1)u pi / 64) for the 32 point DCT used by pHash
+var dctTable = func() (t [8][32]float64) {
+	for u := range t {
+		for x := range t[u] {
+			t[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / 64)
+		}
+	}
+	return
+}()
+
+// pHash sets a bit for every one of the 8x8 lowest frequencies of the DCT of a
+// 32x32 thumbnail that is above their median
+func pHash(img image.Image) (hash uint64) {
+	p := grayPixels(img, 32, 32)
+	// rows first, then columns, only the low frequencies are needed
+	var rows [32][8]float64
+	for y := 0; y < 32; y++ {
+		for u := 0; u < 8; u++ {
+			for x := 0; x < 32; x++ {
+				rows[y][u] += p[y*32+x] * dctTable[u][x]
+			}
+		}
+	}
+	coefs := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			for y := 0; y < 32; y++ {
+				coefs[v*8+u] += rows[y][u] * dctTable[v][y]
+			}
+		}
+	}
+	// the DC term is left out of the median, it only holds the mean brightness
+	sorted := append([]float64{}, coefs[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	for i, c := range coefs {
+		if c > median {
+			hash |= 1 << uint(i)
+		}
+	}
+	return
+}
+
+// hammingDistance counts the bits that differ between a and b
+func hammingDistance(a uint64, b uint64) (n int) {
+	for x := a ^ b; x != 0; x &= x - 1 {
+		n++
+	}
+	return
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"teorem/anydb"
+	"time"
+)
+
+// merge conflict policies
+const (
+	mergeSkip      = "skip"
+	mergeOverwrite = "overwrite"
+	mergeRename    = "rename"
+	mergeFail      = "fail"
+)
+
+// mergeMaxShown is the number of conflicting keys printed
+const mergeMaxShown = 20
+
+// mergeDBs writes the records of all sources into one db, walking them in key order
+// Keys of source i are prefixed with prefixes[i] if given. When several sources, or a
+// record already in the destination, share a key the policy decides what is written:
+// skip keeps the first, overwrite the last, rename adds _<source id> to the later keys
+// (again while the new key is taken) and fail stops the merge
+func mergeDBs(sources []*anydb.ADB, prefixes []string, destType string, destPath string, policy string) {
+	start := time.Now()
+
+	dest, err := anydb.Create(destPath, destType)
+	if err != nil {
+		fmt.Printf("Could not create db: %v\n", err)
+		return
+	}
+	defer dest.Close()
+	// keys already in the destination have to be looked up, some backends (leveldb)
+	// can't tell whether the destination is empty
+	existing, _ := dest.Backend().(anydb.Getter)
+
+	var total uint64
+	var estimated bool
+	for _, src := range sources {
+		src.Scan()
+		src.Reset()
+		n, e := src.EstimateEntries()
+		total += n
+		// a source that can't be counted leaves the total short
+		estimated = estimated || e || n == 0
+	}
+	max := progressTotal(total, estimated)
+	key := func(i int) []byte {
+		if prefixes == nil {
+			return sources[i].Backend().Key()
+		}
+		return append([]byte(prefixes[i]), sources[i].Backend().Key()...)
+	}
+
+	writer := dest.NewWriter(0, 0)
+	var count, conflicts, skipped, overwritten, renamed int
+	var group []int
+	// renamed keys are written out of key order and can be a key a source yields
+	// later, the writer may still hold them so they are remembered here
+	renamedKeys := map[string]bool{}
+	taken := func(k []byte) bool {
+		if renamedKeys[string(k)] {
+			return true
+		}
+		if existing == nil {
+			return false
+		}
+		_, err := existing.Get(k)
+		return err == nil
+	}
+merge_loop:
+	for !InterruptRequested {
+		// sources sharing the smallest key, in selection order
+		var min []byte
+		group = group[:0]
+		for i, src := range sources {
+			if !src.Valid() {
+				continue
+			}
+			k := key(i)
+			c := 1
+			if min != nil {
+				c = bytes.Compare(min, k)
+			}
+			if c > 0 {
+				min = append(min[:0], k...)
+				group = group[:0]
+			}
+			if c >= 0 {
+				group = append(group, i)
+			}
+		}
+		if len(group) == 0 {
+			break
+		}
+
+		inDest := taken(min)
+		if len(group) == 1 && !inDest {
+			err = writer.Put(min, sources[group[0]].Value())
+		} else {
+			conflicts++
+			if conflicts <= mergeMaxShown {
+				fmt.Printf("\rconflict: %s\n", min)
+			}
+			switch policy {
+			case mergeSkip:
+				skipped += len(group)
+				if !inDest {
+					err = writer.Put(min, sources[group[0]].Value())
+					skipped--
+				}
+			case mergeOverwrite:
+				err = writer.Put(min, sources[group[len(group)-1]].Value())
+				overwritten += len(group) - 1
+				if inDest {
+					overwritten++
+				}
+			case mergeRename:
+				for n, i := range group {
+					k := min
+					if n > 0 || inDest {
+						k = []byte(fmt.Sprintf("%s_%v", min, i))
+						for taken(k) {
+							k = []byte(fmt.Sprintf("%s_%v", k, i))
+						}
+						renamedKeys[string(k)] = true
+						renamed++
+					}
+					err = writer.Put(k, sources[i].Value())
+				}
+			default:
+				fmt.Printf("\nStopping at the first conflict\n")
+				writer.Abort()
+				break merge_loop
+			}
+		}
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+		}
+
+		for _, i := range group {
+			sources[i].Next()
+		}
+		count++
+		if count%10 == 0 {
+			fmt.Printf("\r[%v:%v] Merging records...", count, max)
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+	}
+
+	fmt.Printf("\r[%v:%v] Merging records... Done in %v\n", count, max, time.Since(start))
+	fmt.Printf("%v records written in %v commits, %v lost\n", writer.Written(), writer.Commits(), writer.Failed())
+	fmt.Printf("%v conflicts: %v skipped, %v overwritten, %v renamed\n", conflicts, skipped, overwritten, renamed)
+	if conflicts > mergeMaxShown {
+		fmt.Printf("(only the first %v conflicting keys are listed)\n", mergeMaxShown)
+	}
+}
+
+// parseMergeOptions reads [on-conflict skip|overwrite|rename|fail] [prefix p1,p2,...]
+func parseMergeOptions(args []string, sources int) (policy string, prefixes []string, err error) {
+	policy = mergeFail
+	for i := 0; i+1 < len(args); i += 2 {
+		switch strings.ToLower(args[i]) {
+		case "on-conflict":
+			policy = strings.ToLower(args[i+1])
+			if policy != mergeSkip && policy != mergeOverwrite && policy != mergeRename && policy != mergeFail {
+				return "", nil, fmt.Errorf("Unknown conflict policy %v", policy)
+			}
+		case "prefix":
+			prefixes = strings.Split(args[i+1], ",")
+			if len(prefixes) != sources {
+				return "", nil, fmt.Errorf("Expected %v prefixes, one per selected db", sources)
+			}
+		default:
+			return "", nil, fmt.Errorf("Unknown option %v", args[i])
+		}
+	}
+	if len(args)%2 != 0 {
+		err = errors.New("Missing value for " + args[len(args)-1])
+	}
+	return
+}
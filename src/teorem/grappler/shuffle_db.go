@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"teorem/anydb"
+	"time"
+)
+
+// shuffleDB writes all records of src in a random order into a new lmdb
+// Only the keys are held in memory, values are read one by one in permutation order
+// With prefix the new keys are the position plus the original key, like caffe's convert_imageset
+func shuffleDB(src *anydb.ADB, path string, seed int64, prefix bool) {
+	grLog(fmt.Sprintf("shuffle %v:%v to %v", src.Identity(), src.Path(), path))
+
+	getter, ok := src.Backend().(anydb.Getter)
+	if !ok {
+		fmt.Printf("Shuffle needs random access by key, not supported for %v\n", src.Identity())
+		return
+	}
+
+	start := time.Now()
+	max := progressTotal(src.EstimateEntries())
+
+	// collect keys in one buffer, offsets[i] is where key i ends
+	var keys []byte
+	var offsets []int
+	src.Scan()
+	src.Reset()
+	for src.Valid() && !InterruptRequested {
+		keys = append(keys, src.Backend().Key()...)
+		offsets = append(offsets, len(keys))
+		if len(offsets)%10 == 0 {
+			fmt.Printf("\r[%v:%v] Reading keys...", len(offsets), max)
+		}
+		if !src.Next() {
+			break
+		}
+	}
+	n := len(offsets)
+	fmt.Printf("\r[%v:%v] Reading keys... Done\n", n, max)
+	if InterruptRequested {
+		return
+	}
+	if n == 0 {
+		fmt.Printf("No records found\n")
+		return
+	}
+	key := func(i int) []byte {
+		if i == 0 {
+			return keys[:offsets[0]]
+		}
+		return keys[offsets[i-1]:offsets[i]]
+	}
+
+	dest, err := anydb.Create(path, "lmdb")
+	if err != nil {
+		fmt.Printf("Could not create db: %v\n", err)
+		return
+	}
+	defer dest.Close()
+	if dest.Entries() != 0 {
+		fmt.Printf("%v is not empty\n", path)
+		return
+	}
+
+	fmt.Printf("Shuffling %v records with seed %v\n", n, seed)
+	perm := rand.New(rand.NewSource(seed)).Perm(n)
+	writer := dest.NewWriter(0, 0)
+	// zero padded positions keep the order of the keys, at least 8 digits like caffe
+	digits := len(fmt.Sprint(n - 1))
+	if digits < 8 {
+		digits = 8
+	}
+	format := fmt.Sprintf("%%0%vd", digits)
+	var c int
+	for _, i := range perm {
+		if InterruptRequested {
+			break
+		}
+		k := key(i)
+		value, err := getter.Get(k)
+		if err != nil {
+			fmt.Printf("\nCould not read %s: %v\n", k, err)
+			break
+		}
+		newKey := fmt.Sprintf(format, c)
+		if prefix {
+			newKey += "_" + string(k)
+		}
+		err = writer.Put([]byte(newKey), value)
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+		}
+		c++
+		if c%10 == 0 {
+			fmt.Printf("\r[%v:%v] Writing records...", c, n)
+		}
+	}
+	err = writer.Flush()
+	if err != nil {
+		fmt.Printf("\n%v\n", err)
+	}
+	fmt.Printf("\r[%v:%v] Writing records...", c, n)
+
+	stop := time.Since(start)
+	fmt.Printf("\nDone in %v\n", stop)
+	fmt.Printf("%v records written in %v commits, %v lost\n", writer.Written(), writer.Commits(), writer.Failed())
+}
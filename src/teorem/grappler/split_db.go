@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"teorem/anydb"
+	"time"
+)
+
+// splitPart is one of the databases a split writes to
+type splitPart struct {
+	name   string
+	weight float64
+	db     *anydb.ADB
+	writer *anydb.Writer
+}
+
+// parseSplitParts reads name:weight,name:weight,...
+func parseSplitParts(s string) ([]splitPart, error) {
+	var parts []splitPart
+	for _, p := range strings.Split(s, ",") {
+		f := strings.Split(p, ":")
+		if len(f) != 2 || f[0] == "" {
+			return nil, errors.New("Expected name:weight,name:weight,...")
+		}
+		w, err := strconv.ParseFloat(f[1], 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("Malformed weight %v", f[1])
+		}
+		parts = append(parts, splitPart{name: f[0], weight: w})
+	}
+	return parts, nil
+}
+
+// assignSplits spreads the records in indexes over parts, proportionally to their weights
+func assignSplits(indexes []int, parts []splitPart, assigned []int) {
+	var total float64
+	for _, p := range parts {
+		total += p.weight
+	}
+	var cum float64
+	from := 0
+	for i, p := range parts {
+		cum += p.weight
+		to := int(cum/total*float64(len(indexes)) + 0.5)
+		for _, r := range indexes[from:to] {
+			assigned[r] = i
+		}
+		from = to
+	}
+}
+
+// splitDB writes the records of src into one lmdb per part, named after the source path
+// Records are assigned randomly, with stratify each label keeps its proportion in every part
+// A manifest lists the part of every key
+func splitDB(src *anydb.ADB, parts []splitPart, stratify bool, seed int64) {
+	grLog(fmt.Sprintf("split %v:%v", src.Identity(), src.Path()))
+
+	start := time.Now()
+	max := progressTotal(src.EstimateEntries())
+
+	// first pass, read the labels
+	var labels []int32
+	src.Scan()
+	src.Reset()
+	for src.Valid() && !InterruptRequested {
+		label, err := recordLabel(src, src.Backend().Key(), src.Value())
+		if err != nil {
+			fmt.Printf("\nNo label for %s: %v\n", src.Key(), err)
+			return
+		}
+		labels = append(labels, label)
+		if len(labels)%10 == 0 {
+			fmt.Printf("\r[%v:%v] Reading labels...", len(labels), max)
+		}
+		if !src.Next() {
+			break
+		}
+	}
+	n := len(labels)
+	fmt.Printf("\r[%v:%v] Reading labels... Done\n", n, max)
+	if InterruptRequested {
+		return
+	}
+	if n == 0 {
+		fmt.Printf("No records found\n")
+		return
+	}
+
+	// group records by label, or all together
+	groups := make(map[int32][]int)
+	classCount := make(map[int32]bool)
+	for i, l := range labels {
+		classCount[l] = true
+		if !stratify {
+			l = 0
+		}
+		groups[l] = append(groups[l], i)
+	}
+	classes := make([]int, 0, len(groups))
+	for l := range groups {
+		classes = append(classes, int(l))
+	}
+	sort.Ints(classes)
+	rng := rand.New(rand.NewSource(seed))
+	assigned := make([]int, n)
+	for _, l := range classes {
+		g := groups[int32(l)]
+		for i := len(g) - 1; i > 0; i-- {
+			j := rng.Intn(i + 1)
+			g[i], g[j] = g[j], g[i]
+		}
+		assignSplits(g, parts, assigned)
+	}
+
+	for i := range parts {
+		path := src.Path() + "_" + parts[i].name
+		db, err := anydb.Create(path, "lmdb")
+		if err != nil {
+			fmt.Printf("Could not create %v: %v\n", path, err)
+			return
+		}
+		defer db.Close()
+		if db.Entries() != 0 {
+			fmt.Printf("%v is not empty\n", path)
+			return
+		}
+		parts[i].db = db
+		parts[i].writer = db.NewWriter(0, 0)
+	}
+	manifestPath := src.Path() + "_split.txt"
+	f, err := os.Create(manifestPath)
+	if err != nil {
+		fmt.Printf("Could not create manifest: %v\n", err)
+		return
+	}
+	defer f.Close()
+	manifest := bufio.NewWriter(f)
+
+	// second pass, in the same order
+	counts := make([]int, len(parts))
+	var c int
+	src.Reset()
+	for src.Valid() && c < n && !InterruptRequested {
+		p := &parts[assigned[c]]
+		key := src.Backend().Key()
+		err = p.writer.Put(key, src.Value())
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+		}
+		fmt.Fprintf(manifest, "%s %s\n", key, p.name)
+		counts[assigned[c]]++
+		c++
+		if c%10 == 0 {
+			fmt.Printf("\r[%v:%v] Writing records...", c, n)
+		}
+		if !src.Next() {
+			break
+		}
+	}
+	fmt.Printf("\r[%v:%v] Writing records...", c, n)
+	for _, p := range parts {
+		err = p.writer.Flush()
+		if err != nil {
+			fmt.Printf("\n%v\n", err)
+		}
+	}
+	err = manifest.Flush()
+	if err != nil {
+		fmt.Printf("\nCould not write manifest: %v\n", err)
+	}
+
+	stop := time.Since(start)
+	fmt.Printf("\nDone in %v\n", stop)
+	fmt.Printf("Seed %v, %v classes, manifest in %v\n", seed, len(classCount), manifestPath)
+	for i, p := range parts {
+		fmt.Printf("%v: %v records (%v lost) in %v_%v\n", p.name, counts[i], p.writer.Failed(), src.Path(), p.name)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignSplits(t *testing.T) {
+	parts := func(weights ...float64) (p []splitPart) {
+		for _, w := range weights {
+			p = append(p, splitPart{weight: w})
+		}
+		return
+	}
+	tests := []struct {
+		indexes []int
+		parts   []splitPart
+		want    []int
+	}{
+		{[]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, parts(80, 10, 10), []int{0, 0, 0, 0, 0, 0, 0, 0, 1, 2}},
+		{[]int{0, 1, 2, 3}, parts(1, 1), []int{0, 0, 1, 1}},
+		// records go in the order of indexes
+		{[]int{3, 2, 1, 0}, parts(1, 1), []int{1, 1, 0, 0}},
+		{[]int{0, 1, 2}, parts(0, 1), []int{1, 1, 1}},
+		// 300 parts, too many for a byte
+		{[]int{0, 1}, append(parts(make([]float64, 299)...), parts(1)...), []int{299, 299}},
+	}
+	for _, test := range tests {
+		assigned := make([]int, len(test.indexes))
+		assignSplits(test.indexes, test.parts, assigned)
+		if !reflect.DeepEqual(assigned, test.want) {
+			t.Errorf("%v over %v parts: got %v, want %v", test.indexes, len(test.parts), assigned, test.want)
+		}
+	}
+}
+
+func TestParseSplitParts(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []splitPart
+		ok   bool
+	}{
+		{"train:80,val:20", []splitPart{{name: "train", weight: 80}, {name: "val", weight: 20}}, true},
+		{"all:1", []splitPart{{name: "all", weight: 1}}, true},
+		{"train:80,val", nil, false},
+		{":80", nil, false},
+		{"train:-1", nil, false},
+		{"train:x", nil, false},
+	}
+	for _, test := range tests {
+		parts, err := parseSplitParts(test.s)
+		if (err == nil) != test.ok || !reflect.DeepEqual(parts, test.want) {
+			t.Errorf("%q: got %v, %v", test.s, parts, err)
+		}
+	}
+}
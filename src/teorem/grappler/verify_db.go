@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"teorem/anydb"
+	"teorem/grappler/caffe"
+	"teorem/multimatrix/matchar"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/golang/protobuf/proto"
+)
+
+// verifyMaxShown is the number of corrupt records printed
+const verifyMaxShown = 20
+
+// datumDims returns the dimensions of a valid caffe Datum, holding C*H*W bytes or floats
+// with all three dims set, or an image that decodes, whose bounds are used then.
+// Returns what is wrong, or ""
+func datumDims(value []byte) (channels int32, height int32, width int32, problem string) {
+	d := &caffe.Datum{}
+	err := proto.Unmarshal(value, d)
+	if err != nil {
+		return 0, 0, 0, "not a Datum"
+	}
+	if d.GetEncoded() {
+		img, err := imaging.Decode(bytes.NewReader(d.GetData()))
+		if err != nil {
+			return 0, 0, 0, "image does not decode"
+		}
+		b := img.Bounds()
+		return d.GetChannels(), int32(b.Dy()), int32(b.Dx()), ""
+	}
+	channels, height, width = d.GetChannels(), d.GetHeight(), d.GetWidth()
+	if channels <= 0 || height <= 0 || width <= 0 {
+		return 0, 0, 0, "dims are not set"
+	}
+	size := int(channels) * int(height) * int(width)
+	if len(d.GetData()) != size && len(d.GetFloatData()) != size {
+		return 0, 0, 0, "data size is not C*H*W"
+	}
+	return
+}
+
+// verifyDatum checks that value is a valid Datum with the given dimensions, see datumDims
+// Channels are only compared when both are set, encoded Datums often leave them out
+func verifyDatum(value []byte, channels int32, height int32, width int32) string {
+	c, h, w, problem := datumDims(value)
+	if problem != "" {
+		return problem
+	}
+	if h != height || w != width || (c != channels && c != 0 && channels != 0) {
+		return "dims differ from first valid record"
+	}
+	return ""
+}
+
+// verifyDB scans a db of caffe Datums with a pool of workers and stores the keys of
+// corrupt records in a char matrix
+func verifyDB(db *anydb.ADB, variable string) {
+	start := time.Now()
+	max := progressTotal(db.EstimateEntries())
+
+	db.Scan()
+	db.Reset()
+	if !db.Valid() {
+		fmt.Printf("No records found\n")
+		return
+	}
+
+	// dimensions of the first valid record are expected everywhere, the records before
+	// it are verified with the others
+	var channels, height, width int32
+	found := false
+	for db.Valid() && !InterruptRequested {
+		var problem string
+		channels, height, width, problem = datumDims(db.Value())
+		if problem == "" {
+			found = true
+			break
+		}
+		if !db.Next() {
+			break
+		}
+	}
+	if !found {
+		if !InterruptRequested {
+			fmt.Printf("Did not find a valid caffe.Datum in database\n")
+		}
+		return
+	}
+	fmt.Printf("Expecting %vx%vx%v Datums\n", channels, height, width)
+	db.Reset()
+
+	type verifyJob struct {
+		key   string
+		value []byte
+	}
+	type verifyResult struct {
+		key     string
+		problem string
+	}
+	jobs := make(chan verifyJob, 100)
+	results := make(chan verifyResult, 100)
+
+	// LOADER
+	go func() {
+		for db.Valid() && !InterruptRequested {
+			// copy, some backends reuse the value buffer when moving on
+			jobs <- verifyJob{string(db.Key()), append([]byte(nil), db.Value()...)}
+			if !db.Next() {
+				break
+			}
+		}
+		close(jobs)
+	}()
+
+	workers := config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				results <- verifyResult{j.key, verifyDatum(j.value, channels, height, width)}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var corrupt []verifyResult
+	problems := make(map[string]int)
+	var c int
+	for r := range results {
+		if r.problem != "" {
+			corrupt = append(corrupt, r)
+			problems[r.problem]++
+			if len(corrupt) <= verifyMaxShown {
+				fmt.Printf("\r%s: %s\n", r.key, r.problem)
+			}
+		}
+		c++
+		if c%10 == 0 {
+			fmt.Printf("\r[%v:%v] (corrupt: %v) Verifying...", c, max, len(corrupt))
+		}
+	}
+	fmt.Printf("\r[%v:%v] (corrupt: %v) Verifying... Done in %v\n", c, max, len(corrupt), time.Since(start))
+	if InterruptRequested {
+		fmt.Printf("Interrupted, not all records were verified\n")
+	}
+
+	// workers finish in any order, keep the keys sorted
+	keys := make([]string, len(corrupt))
+	for i, r := range corrupt {
+		keys[i] = r.key
+	}
+	sort.Strings(keys)
+	matrixesChar[variable] = matchar.NewMatchar(keys)
+
+	if len(corrupt) == 0 {
+		fmt.Printf("All %v records are fine\n", c)
+		return
+	}
+	fmt.Printf("%v of %v records are corrupt, keys stored in %v\n", len(corrupt), c, variable)
+	for p, n := range problems {
+		fmt.Printf("  %v: %v\n", p, n)
+	}
+}
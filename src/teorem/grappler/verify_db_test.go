@@ -0,0 +1,46 @@
+package main
+
+import (
+	"teorem/grappler/caffe"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestVerifyDatum(t *testing.T) {
+	dims := func(c, h, w int32) *caffe.Datum {
+		return &caffe.Datum{Channels: proto.Int32(c), Height: proto.Int32(h), Width: proto.Int32(w)}
+	}
+	withData := func(d *caffe.Datum, n int) *caffe.Datum {
+		d.Data = make([]byte, n)
+		return d
+	}
+	tests := []struct {
+		datum *caffe.Datum
+		want  string
+	}{
+		{withData(dims(3, 2, 2), 12), ""},
+		{&caffe.Datum{Channels: proto.Int32(3), Height: proto.Int32(2), Width: proto.Int32(2), FloatData: make([]float32, 12)}, ""},
+		{withData(dims(3, 2, 2), 11), "data size is not C*H*W"},
+		// an empty Datum has no image
+		{&caffe.Datum{}, "dims are not set"},
+		{dims(0, 0, 0), "dims are not set"},
+		{withData(dims(-1, -2, 2), 4), "dims are not set"},
+		// C*H*W overflows an int32
+		{withData(dims(2, 1<<16, 1<<15), 0), "data size is not C*H*W"},
+		{withData(dims(3, 4, 4), 48), "dims differ from first valid record"},
+		{withData(&caffe.Datum{Height: proto.Int32(2), Width: proto.Int32(2)}, 4), "dims are not set"},
+	}
+	for i, test := range tests {
+		value, err := proto.Marshal(test.datum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := verifyDatum(value, 3, 2, 2); got != test.want {
+			t.Errorf("%v: got %q, want %q", i, got, test.want)
+		}
+	}
+	if got := verifyDatum([]byte{0xff}, 3, 2, 2); got != "not a Datum" {
+		t.Errorf("garbage: got %q", got)
+	}
+}